@@ -1,5 +1,9 @@
 module github.com/cosaques/algolia
 
-go 1.16
+go 1.17
 
-require github.com/gorilla/websocket v1.4.2
+require (
+	github.com/gorilla/websocket v1.4.2
+	github.com/klauspost/compress v1.13.6
+	golang.org/x/text v0.3.7
+)
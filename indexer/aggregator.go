@@ -1,50 +1,422 @@
 package indexer
 
-import "sync"
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
 
 // Aggregator is an abstraction of index aggregation.
 type Aggregator interface {
 	// Add adds a new Trace to all the concerned indexes.
 	Add(Trace)
-	// GetIndex returns an index for a given TimeRange.
-	GetIndex(TimeRange) Index
+	// AddBatch adds several Traces to all the concerned indexes.
+	AddBatch([]Trace)
+	// Variants returns the raw queries normalized into the same query as a given one
+	// with their counts, the most frequent first. It is nil without normalization.
+	Variants(query string) []TopQuery
+	// GetIndex returns an index for a given TimeRange,
+	// TimeRanges of other time zones than UTC are covered by UTC indexes.
+	// It fails with ErrPrecisionNotIndexed if the precision isn't maintained.
+	GetIndex(TimeRange) (Index, error)
+	// GetRange returns an index for an arbitrary time range [from, to).
+	GetRange(from, to time.Time) (Index, error)
+	// Series returns the count of a query for each TimeRange of a given precision
+	// dividing the time range [from, to), 0 for the ones without index.
+	// It fails with ErrInvalidStep if the TimeRanges don't divide the range.
+	Series(query string, from, to time.Time, step TimePrecision) ([]SeriesPoint, error)
+	// Trending returns the queries whose count grew the most during a TimeRange
+	// compared to the previous one of the same precision, scored by a given method,
+	// the highest score first, at most size of them.
+	Trending(r TimeRange, method TrendMethod, size int) ([]TrendingQuery, error)
+	// Merge adds the queries of the indexes of another aggregator to the indexes
+	// of the same TimeRanges, e.g. built from another logs file.
+	Merge(Aggregator) error
+	// Interner returns the Interner caching the queries of memory indexes.
+	Interner() *Interner
+	// Evict drops the indexes older than the retention of their precision,
+	// and returns their number.
+	Evict() int
+	// WriteTo writes a snapshot of all the indexes,
+	// it could be loaded back with LoadAggregator.
+	io.WriterTo
+	// Close stops the eviction and closes all the indexes,
+	// the aggregator shouldn't be used anymore.
+	io.Closer
 }
 
 // aggregator contains indexes for each possible TimeRange.
 type aggregator struct {
+	// watermark is the date of the latest indexed trace in Unix nanoseconds,
+	// it is first to be aligned for atomic operations.
+	watermark int64
+	// earliest is the date of the earliest indexed trace in Unix nanoseconds,
+	// it is 0 till a trace is indexed.
+	earliest int64
 	// indexes is a map of indexes for presented TimeRanges.
 	indexes map[string]Index
 	// mux allows to read/write maps and slices in concurrent way.
 	mux sync.RWMutex
+	// newIndex creates the index of a TimeRange.
+	newIndex IndexFactory
+	// approxErrorRate is the standard error of distinct counts
+	// of coarse indexes, they are exact if it is 0.
+	approxErrorRate float64
+	// topKCapacity is the number of queries monitored by
+	// coarse indexes, they keep all the queries if it is 0.
+	topKCapacity int
+	// precisions are the maintained precisions.
+	precisions []TimePrecision
+	// normalize transforms queries before they are indexed, they are kept if it is nil.
+	normalize Normalizer
+	// variants are the counts of raw queries by normalized query.
+	variants map[string]map[string]int
+	// variantsLen is the number of raw queries of variants.
+	variantsLen int
+	// maxVariants is the maximum number of raw queries of variants.
+	maxVariants int
+	// variantsMux allows to read/write variants in concurrent way.
+	variantsMux sync.RWMutex
+	// retentions are the durations indexes are kept after the watermark by precision,
+	// indexes of other precisions are kept forever.
+	retentions map[TimePrecision]time.Duration
+	// evictionInterval is the periodicity of evictions.
+	evictionInterval time.Duration
+	// done stops the evictor, it is nil if there is none.
+	done chan struct{}
+	// closeOnce allows to close the aggregator once.
+	closeOnce sync.Once
+	// interner caches the queries of memory indexes.
+	interner *Interner
+	// rollUp tells if indexes of coarse precisions are derived from finer ones
+	// instead of being fed with traces.
+	rollUp bool
+	// sealMux allows to seal derived indexes while no trace is being ingested.
+	sealMux sync.RWMutex
+	// unflushed are the TimeRanges of the Flusher indexes by key,
+	// their indexes are flushed once sealed so that past queries aren't kept in memory.
+	unflushed map[string]TimeRange
+	// nextFlush is the earliest end of unflushed in Unix nanoseconds, 0 if there are none.
+	nextFlush int64
 }
 
+// SeriesPoint is the count of a query during a TimeRange.
+type SeriesPoint struct {
+	Range TimeRange
+	Count int
+}
+
+var (
+	// ErrPrecisionNotIndexed is returned when the indexes of a precision aren't maintained.
+	ErrPrecisionNotIndexed = errors.New("Precision not indexed")
+	// ErrInvalidStep is returned when a series can't be computed with a given step.
+	ErrInvalidStep = errors.New("Invalid step")
+)
+
+// maxSeriesPoints is the maximum number of points of a series.
+const maxSeriesPoints = 100000
+
+// allPrecisions are all the possible time precisions.
+var allPrecisions = []TimePrecision{Year, Quarter, Month, Week, Day, Hour, Minute, Second}
+
+type (
+	// Option allows to configure an aggregator.
+	Option func(*aggregator)
+
+	// IndexFactory creates an index for a given TimeRange.
+	IndexFactory func(TimeRange) Index
+)
+
+// WithIndexFactory sets the way indexes are created,
+// by default they are created with NewMemoryIndexWithInterner sharing the Interner of the aggregator.
+func WithIndexFactory(f IndexFactory) Option {
+	return func(a *aggregator) {
+		a.newIndex = f
+	}
+}
+
+// WithDiskIndexes makes the aggregator store indexes in dir using NewDiskIndex,
+// each TimeRange gets its own subdirectory.
+func WithDiskIndexes(dir string, headSize int) Option {
+	// TimeRange keys contain characters not allowed in some file systems.
+	replacer := strings.NewReplacer(" ", "T", ":", "-")
+	return WithIndexFactory(func(r TimeRange) Index {
+		return NewDiskIndex(filepath.Join(dir, replacer.Replace(r.String())), headSize)
+	})
+}
+
+// WithApproximateCount makes Year, Quarter, Month, Week and Day indexes estimate their
+// count of distinct queries with a given standard error instead of keeping them.
+// Their popular queries are then computed from Hour indexes.
+func WithApproximateCount(errorRate float64) Option {
+	return func(a *aggregator) {
+		a.approxErrorRate = errorRate
+	}
+}
+
+// WithTopK makes Year, Quarter, Month, Week and Day indexes keep only a bounded number
+// of the most popular queries using NewTopKIndex. Their count of distinct
+// queries is estimated with the standard error of WithApproximateCount if any.
+func WithTopK(capacity int) Option {
+	return func(a *aggregator) {
+		a.topKCapacity = capacity
+	}
+}
+
+// WithPrecisions sets the maintained precisions, by default all of them are.
+// Coarse indexes are only approximated (see WithApproximateCount and WithTopK)
+// if Hour is maintained.
+func WithPrecisions(precisions ...TimePrecision) Option {
+	return func(a *aggregator) {
+		a.precisions = precisions
+	}
+}
+
+// WithNormalizers makes the aggregator transform queries with given normalizers
+// applied in order before indexing them, e.g. WithNormalizers(TrimSpace, CaseFold).
+// Traces whose normalized query is empty are ignored.
+func WithNormalizers(normalizers ...Normalizer) Option {
+	return func(a *aggregator) {
+		a.normalize = ChainNormalizers(normalizers...)
+		a.variants = make(map[string]map[string]int)
+	}
+}
+
+// defaultMaxVariants is the maximum number of counted raw queries if no other is set.
+const defaultMaxVariants = 100000
+
+// WithMaxVariants sets the maximum number of raw queries counted as variants of normalized ones,
+// so that their memory is bounded. Once it's reached, only the known raw queries are counted.
+func WithMaxVariants(n int) Option {
+	return func(a *aggregator) {
+		a.maxVariants = n
+	}
+}
+
+// WithInterner makes the memory indexes of the aggregator cache their queries with
+// a given Interner, e.g. shared by several aggregators. Each aggregator has its own by default.
+func WithInterner(interner *Interner) Option {
+	return func(a *aggregator) {
+		a.interner = interner
+	}
+}
+
+// WithRetention makes the aggregator drop the indexes of a precision once they end
+// more than retention before the latest indexed trace (the watermark), e.g.
+// WithRetention(Minute, 48*time.Hour). Indexes of other precisions are kept forever.
+// Popular queries of approximate indexes are partial once their Hour indexes are dropped.
+func WithRetention(precision TimePrecision, retention time.Duration) Option {
+	return func(a *aggregator) {
+		if a.retentions == nil {
+			a.retentions = make(map[TimePrecision]time.Duration)
+		}
+		a.retentions[precision] = retention
+	}
+}
+
+// WithEvictionInterval sets the periodicity of the eviction of the indexes
+// older than their retention, it is defaultEvictionInterval by default.
+func WithEvictionInterval(interval time.Duration) Option {
+	return func(a *aggregator) {
+		a.evictionInterval = interval
+	}
+}
+
+// defaultEvictionInterval is the periodicity of evictions if no other is set.
+const defaultEvictionInterval = time.Minute
+
 // NewAggregator creates an instance of aggregator.
-func NewAggregator() Aggregator {
-	return &aggregator{
-		indexes: make(map[string]Index),
+func NewAggregator(options ...Option) Aggregator {
+	a := &aggregator{
+		indexes:          make(map[string]Index),
+		unflushed:        make(map[string]TimeRange),
+		interner:         NewInterner(),
+		precisions:       allPrecisions,
+		evictionInterval: defaultEvictionInterval,
+		maxVariants:      defaultMaxVariants,
 	}
+	for _, option := range options {
+		option(a)
+	}
+	if a.newIndex == nil {
+		a.newIndex = func(TimeRange) Index {
+			return NewMemoryIndexWithInterner(a.interner)
+		}
+	}
+
+	if len(a.retentions) > 0 && a.evictionInterval > 0 {
+		a.done = make(chan struct{})
+		go a.evictPeriodically()
+	}
+	return a
 }
 
 // Add adds a new Trace to all the concerned indexes.
 func (a *aggregator) Add(t Trace) {
-	// All possible time precisions.
-	precisions := []TimePrecision{Year, Month, Day, Hour, Minute}
+	// Indexes are bucketed in UTC whatever the time zone of traces is.
+	t.Date = t.Date.UTC()
+
+	var ok bool
+	if t.Query, ok = a.normalizeQuery(t.Query); !ok {
+		return
+	}
+
+	a.updateWatermark(t.Date)
+	if a.rollUp {
+		a.sealMux.RLock()
+		defer a.sealMux.RUnlock()
+	}
+
+	// Index Trace with all maintained time precisions.
+	var wg sync.WaitGroup
+	for _, precision := range a.precisions {
+		r := TimeRange{t.Date, precision}
+		if a.expired(r) {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if index := a.ingestIndex(r); index != nil {
+				index.Add(t.Query)
+			}
+		}()
+	}
+	wg.Wait()
+	a.flushSealed()
+}
+
+// AddBatch adds several Traces to all the concerned indexes,
+// each index getting all its queries at once.
+func (a *aggregator) AddBatch(traces []Trace) {
+	if a.normalize != nil {
+		normalized := make([]Trace, 0, len(traces))
+		for _, t := range traces {
+			var ok bool
+			if t.Query, ok = a.normalizeQuery(t.Query); ok {
+				normalized = append(normalized, t)
+			}
+		}
+		traces = normalized
+	}
+	for _, t := range traces {
+		a.updateWatermark(t.Date)
+	}
+	if a.rollUp {
+		a.sealMux.RLock()
+		defer a.sealMux.RUnlock()
+	}
 
-	// Index Trace with all possible time precisions.
 	var wg sync.WaitGroup
-	for _, precision := range precisions {
+	for _, precision := range a.precisions {
 		wg.Add(1)
 		go func(precision TimePrecision) {
 			defer wg.Done()
-			index := a.getOrCreateIndex(TimeRange{t.Date, precision})
-			index.Add(t.Query)
+
+			// Group queries by TimeRange, ranges being identified by their start.
+			groups := make(map[TimeRange][]string)
+			for _, t := range traces {
+				// Indexes are bucketed in UTC whatever the time zone of traces is.
+				r := TimeRange{t.Date.UTC(), precision}
+				r.Date = r.Start()
+				groups[r] = append(groups[r], t.Query)
+			}
+
+			for r, queries := range groups {
+				if a.expired(r) {
+					continue
+				}
+				if index := a.ingestIndex(r); index != nil {
+					addBatch(index, queries)
+				}
+			}
 		}(precision)
 	}
 	wg.Wait()
+	a.flushSealed()
+}
+
+// normalizeQuery normalizes a raw query and counts it as a variant,
+// ok is false if the query has to be ignored.
+func (a *aggregator) normalizeQuery(raw string) (query string, ok bool) {
+	if a.normalize == nil {
+		return raw, true
+	}
+
+	query = a.normalize(raw)
+	if query == "" {
+		return "", false
+	}
+
+	a.variantsMux.Lock()
+	defer a.variantsMux.Unlock()
+	a.addVariant(query, raw, 1)
+
+	return query, true
+}
+
+// addVariant counts a raw query as a variant of a normalized one unless there are
+// maxVariants raw queries already. variantsMux has to be locked.
+func (a *aggregator) addVariant(query, raw string, count int) {
+	if _, exists := a.variants[query][raw]; !exists {
+		if a.variantsLen >= a.maxVariants {
+			return
+		}
+		a.variantsLen++
+	}
+
+	if a.variants[query] == nil {
+		a.variants[query] = make(map[string]int)
+	}
+	a.variants[query][raw] += count
+}
+
+// Variants returns the raw queries normalized into the same query as a given one.
+func (a *aggregator) Variants(query string) []TopQuery {
+	if a.normalize == nil {
+		return nil
+	}
+
+	a.variantsMux.RLock()
+	defer a.variantsMux.RUnlock()
+
+	result := []TopQuery{}
+	for raw, count := range a.variants[a.normalize(query)] {
+		result = append(result, TopQuery{Query: raw, Count: count})
+	}
+	return sortTopQueries(result, -1)
 }
 
 // GetIndex returns an index for a given TimeRange.
-func (a *aggregator) GetIndex(r TimeRange) Index {
+func (a *aggregator) GetIndex(r TimeRange) (Index, error) {
+	if !a.maintains(r.Precision) {
+		return nil, fmt.Errorf("aggregator.GetIndex(): %w: %v.", ErrPrecisionNotIndexed, r.Precision)
+	}
+	if r.Date.Location() != time.UTC {
+		return a.getZonedIndex(r)
+	}
+
+	return a.getIndex(r), nil
+}
+
+// getIndex returns an index of a maintained precision, nil if there is none.
+// Indexes derived from finer ones are rolled up if needed.
+func (a *aggregator) getIndex(r TimeRange) Index {
+	if idx := a.lookupIndex(r); idx != nil || !a.derived(r.Precision) {
+		return idx
+	}
+	return a.rollUpIndex(r)
+}
+
+// lookupIndex returns an existing index, nil if there is none.
+func (a *aggregator) lookupIndex(r TimeRange) Index {
 	idxKey := r.String()
 
 	a.mux.RLock()
@@ -53,6 +425,320 @@ func (a *aggregator) GetIndex(r TimeRange) Index {
 	return a.indexes[idxKey]
 }
 
+// maintains tells if indexes of a precision are maintained.
+func (a *aggregator) maintains(precision TimePrecision) bool {
+	for _, p := range a.precisions {
+		if p == precision {
+			return true
+		}
+	}
+	return false
+}
+
+// GetRange returns an index for an arbitrary time range [from, to).
+// The result is a read-only composition of the indexes covering the range,
+// it is nil if none of them exists.
+func (a *aggregator) GetRange(from, to time.Time) (Index, error) {
+	if !from.Before(to) {
+		return nil, fmt.Errorf("aggregator.GetRange(): %v is not before %v.", from, to)
+	}
+
+	// Indexes are bucketed in UTC.
+	cover, ok := coverTimeRanges(from.UTC(), to.UTC(), a.maintains)
+	if !ok {
+		return nil, fmt.Errorf("aggregator.GetRange(): %w: [%v, %v) can't be covered.", ErrPrecisionNotIndexed, from, to)
+	}
+
+	var indexes []Index
+	for _, r := range cover {
+		if idx := a.getIndex(r); idx != nil {
+			indexes = append(indexes, idx)
+		}
+	}
+
+	switch len(indexes) {
+	case 0:
+		return nil, nil
+	case 1:
+		return indexes[0], nil
+	default:
+		return newUnionIndex(indexes), nil
+	}
+}
+
+// Series returns the count of a query for each TimeRange of a given precision
+// dividing the time range [from, to), TimeRanges being in the time zone of from.
+func (a *aggregator) Series(query string, from, to time.Time, step TimePrecision) ([]SeriesPoint, error) {
+	if !a.maintains(step) {
+		return nil, fmt.Errorf("aggregator.Series(): %w: %v.", ErrPrecisionNotIndexed, step)
+	}
+	if !from.Before(to) {
+		return nil, fmt.Errorf("aggregator.Series(): %v is not before %v.", from, to)
+	}
+	if !(TimeRange{from, step}).Start().Equal(from) {
+		return nil, fmt.Errorf("aggregator.Series(): %w: %v doesn't start a %v.", ErrInvalidStep, from, step)
+	}
+
+	// Queries are indexed normalized.
+	if a.normalize != nil {
+		query = a.normalize(query)
+	}
+
+	var result []SeriesPoint
+	for start := from; start.Before(to); {
+		r := TimeRange{start, step}
+		end := r.End()
+		if end.After(to) {
+			return nil, fmt.Errorf("aggregator.Series(): %w: %v doesn't end a %v.", ErrInvalidStep, to, step)
+		}
+		if len(result) == maxSeriesPoints {
+			return nil, fmt.Errorf("aggregator.Series(): %w: more than %d points.", ErrInvalidStep, maxSeriesPoints)
+		}
+
+		point := SeriesPoint{Range: r}
+		idx, err := a.GetIndex(r)
+		if err != nil {
+			return nil, fmt.Errorf("aggregator.Series(): %w.", err)
+		}
+		if idx != nil {
+			point.Count = Count(idx, query)
+		}
+		result = append(result, point)
+
+		start = end
+	}
+	return result, nil
+}
+
+// Trending returns the queries whose count grew the most during a TimeRange
+// compared to the previous one of the same precision.
+func (a *aggregator) Trending(r TimeRange, method TrendMethod, size int) ([]TrendingQuery, error) {
+	current, err := a.GetIndex(r)
+	if err != nil {
+		return nil, fmt.Errorf("aggregator.Trending(): %w.", err)
+	}
+	previous, err := a.GetIndex(r.Prev())
+	if err != nil {
+		return nil, fmt.Errorf("aggregator.Trending(): %w.", err)
+	}
+
+	return trending(current, previous, method, size), nil
+}
+
+// updateWatermark moves the watermark to a date if it's later,
+// and the earliest date to it if it's earlier.
+func (a *aggregator) updateWatermark(date time.Time) {
+	nanos := date.UnixNano()
+	for {
+		watermark := atomic.LoadInt64(&a.watermark)
+		if nanos <= watermark || atomic.CompareAndSwapInt64(&a.watermark, watermark, nanos) {
+			break
+		}
+	}
+	for {
+		earliest := atomic.LoadInt64(&a.earliest)
+		if (earliest != 0 && nanos >= earliest) || atomic.CompareAndSwapInt64(&a.earliest, earliest, nanos) {
+			return
+		}
+	}
+}
+
+// expired tells if the index of a TimeRange is older than the retention of its precision.
+func (a *aggregator) expired(r TimeRange) bool {
+	retention, ok := a.retentions[r.Precision]
+	if !ok {
+		return false
+	}
+
+	watermark := time.Unix(0, atomic.LoadInt64(&a.watermark))
+	return !r.End().After(watermark.Add(-retention))
+}
+
+// flushSealed flushes the Flusher indexes whose TimeRange is sealed,
+// their late queries are then kept in memory till they are flushed again.
+func (a *aggregator) flushSealed() {
+	if nextFlush := atomic.LoadInt64(&a.nextFlush); nextFlush == 0 || nextFlush > atomic.LoadInt64(&a.watermark) {
+		return
+	}
+
+	var sealed []Flusher
+	a.mux.Lock()
+	nextFlush := int64(0)
+	for key, r := range a.unflushed {
+		if a.sealed(r) {
+			delete(a.unflushed, key)
+			sealed = append(sealed, a.indexes[key].(Flusher))
+		} else if end := r.End().UnixNano(); nextFlush == 0 || end < nextFlush {
+			nextFlush = end
+		}
+	}
+	atomic.StoreInt64(&a.nextFlush, nextFlush)
+	a.mux.Unlock()
+
+	for _, idx := range sealed {
+		if err := idx.Flush(); err != nil {
+			log.Printf("aggregator.flushSealed(): %v.", err)
+		}
+	}
+}
+
+// Merge adds the queries of the indexes of another aggregator to the indexes
+// of the same TimeRanges. Queries are merged as they are, whatever the normalization of
+// the aggregators is. Indexes of precisions which aren't maintained by both are skipped.
+func (a *aggregator) Merge(other Aggregator) error {
+	o, ok := other.(*aggregator)
+	if !ok {
+		return fmt.Errorf("aggregator.Merge(): %T can't be merged.", other)
+	}
+	if o == a {
+		return fmt.Errorf("aggregator.Merge(): an aggregator can't be merged into itself.")
+	}
+
+	o.mux.RLock()
+	ranges := make([]TimeRange, 0, len(o.indexes))
+	for key := range o.indexes {
+		if r, err := ParseTimeRange(key); err == nil {
+			ranges = append(ranges, r)
+		}
+	}
+	o.mux.RUnlock()
+
+	// Get the indexes of other for the TimeRanges covering its indexes, derived ones included,
+	// before ingesting them so that both aggregators aren't locked at once.
+	sources := make(map[TimeRange]Index)
+	for _, r := range ranges {
+		for _, precision := range a.precisions {
+			covering := TimeRange{r.Date, precision}
+			covering.Date = covering.Start()
+			if _, exists := sources[covering]; exists || !o.maintains(precision) ||
+				covering.Start().After(r.Start()) || covering.End().Before(r.End()) {
+				continue
+			}
+			sources[covering] = o.getIndex(covering)
+		}
+	}
+
+	if earliest := atomic.LoadInt64(&o.earliest); earliest != 0 {
+		a.updateWatermark(time.Unix(0, earliest))
+		a.updateWatermark(time.Unix(0, atomic.LoadInt64(&o.watermark)))
+	}
+	if a.rollUp {
+		a.sealMux.RLock()
+		defer a.sealMux.RUnlock()
+	}
+	for r, src := range sources {
+		if src == nil || a.expired(r) {
+			continue
+		}
+		// Derived indexes only get the queries once sealed, like traces.
+		if dst := a.ingestIndex(r); dst != nil {
+			if err := Merge(dst, src); err != nil {
+				return fmt.Errorf("aggregator.Merge(): %w.", err)
+			}
+		}
+	}
+	a.flushSealed()
+
+	if a.variants != nil && o.variants != nil {
+		o.variantsMux.RLock()
+		defer o.variantsMux.RUnlock()
+		a.variantsMux.Lock()
+		defer a.variantsMux.Unlock()
+		for query, variants := range o.variants {
+			for raw, count := range variants {
+				a.addVariant(query, raw, count)
+			}
+		}
+	}
+	return nil
+}
+
+// Interner returns the Interner caching the queries of memory indexes.
+func (a *aggregator) Interner() *Interner {
+	return a.interner
+}
+
+// Evict drops the indexes older than the retention of their precision:
+// their indexation is stopped and their cached queries are released.
+func (a *aggregator) Evict() int {
+	if len(a.retentions) == 0 {
+		return 0
+	}
+
+	// Derived indexes are rolled up before their children are dropped.
+	if a.rollUp {
+		a.sealParents()
+	}
+
+	var evicted []Index
+	a.mux.Lock()
+	for key, idx := range a.indexes {
+		if r, err := ParseTimeRange(key); err == nil && a.expired(r) {
+			delete(a.indexes, key)
+			delete(a.unflushed, key)
+			evicted = append(evicted, idx)
+		}
+	}
+	a.mux.Unlock()
+
+	for _, idx := range evicted {
+		if err := dropIndex(idx); err != nil {
+			log.Printf("aggregator.Evict(): %v.", err)
+		}
+	}
+	if len(evicted) > 0 {
+		a.interner.Sweep()
+	}
+	return len(evicted)
+}
+
+// evictPeriodically evicts old indexes till the aggregator is closed.
+func (a *aggregator) evictPeriodically() {
+	ticker := time.NewTicker(a.evictionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			a.Evict()
+		case <-a.done:
+			return
+		}
+	}
+}
+
+// Close stops the eviction and closes all the indexes.
+func (a *aggregator) Close() error {
+	var err error
+	a.closeOnce.Do(func() {
+		if a.done != nil {
+			close(a.done)
+		}
+
+		a.mux.RLock()
+		defer a.mux.RUnlock()
+		for _, idx := range a.indexes {
+			if closeErr := idx.Close(); closeErr != nil && err == nil {
+				err = fmt.Errorf("aggregator.Close(): %w.", closeErr)
+			}
+		}
+		a.interner.Sweep()
+	})
+	return err
+}
+
+// getZonedIndex returns an index for a TimeRange of another time zone than UTC.
+// The UTC index is used if it has the same bounds (e.g. hours of most time zones),
+// otherwise the UTC indexes covering the TimeRange are composed.
+func (a *aggregator) getZonedIndex(r TimeRange) (Index, error) {
+	start, end := r.Start(), r.End()
+	if utc := (TimeRange{start.UTC(), r.Precision}); utc.Start().Equal(start) && utc.End().Equal(end) {
+		return a.getIndex(utc), nil
+	}
+
+	return a.GetRange(start, end)
+}
+
 // getOrCreateIndex returns either existing index or
 // a newly created for a given TimeRange.
 func (a *aggregator) getOrCreateIndex(r TimeRange) Index {
@@ -65,9 +751,15 @@ func (a *aggregator) getOrCreateIndex(r TimeRange) Index {
 		a.mux.Lock()
 		defer a.mux.Unlock()
 		if idx, exist := a.indexes[idxKey]; !exist {
-			// Insert the new string.
-			idx = NewMemoryIndex()
+			// Insert the new index.
+			idx = a.createIndex(r)
 			a.indexes[idxKey] = idx
+			if _, ok := idx.(Flusher); ok {
+				a.unflushed[idxKey] = r
+				if end := r.End().UnixNano(); a.nextFlush == 0 || end < a.nextFlush {
+					atomic.StoreInt64(&a.nextFlush, end)
+				}
+			}
 			return idx
 		} else {
 			return idx
@@ -77,3 +769,48 @@ func (a *aggregator) getOrCreateIndex(r TimeRange) Index {
 		return idx
 	}
 }
+
+// defaultErrorRate is the standard error of distinct counts
+// of top-K indexes if no other is set.
+const defaultErrorRate = 0.01
+
+// createIndex creates the index of a TimeRange according to the options.
+func (a *aggregator) createIndex(r TimeRange) Index {
+	if !a.isApproximate(r.Precision) {
+		return a.newIndex(r)
+	}
+
+	switch {
+	case a.topKCapacity > 0:
+		errorRate := a.approxErrorRate
+		if errorRate == 0 {
+			errorRate = defaultErrorRate
+		}
+		return newTopKIndex(a.topKCapacity, errorRate)
+	default:
+		return newSketchIndex(a.approxErrorRate, func() Index {
+			return a.getHourIndexes(r)
+		})
+	}
+}
+
+// isApproximate tells if indexes of a given precision are approximate.
+func (a *aggregator) isApproximate(precision TimePrecision) bool {
+	return (a.approxErrorRate > 0 || a.topKCapacity > 0) && precision.coarserThanHour() && a.maintains(Hour)
+}
+
+// getHourIndexes returns the union of Hour indexes of a given TimeRange,
+// it is nil if there are none.
+func (a *aggregator) getHourIndexes(r TimeRange) Index {
+	var indexes []Index
+	for hour := r.Start(); hour.Before(r.End()); hour = hour.Add(time.Hour) {
+		if idx := a.getIndex(TimeRange{hour, Hour}); idx != nil {
+			indexes = append(indexes, idx)
+		}
+	}
+
+	if len(indexes) == 0 {
+		return nil
+	}
+	return newUnionIndex(indexes)
+}
@@ -2,8 +2,13 @@ package indexer_test
 
 import (
 	"errors"
+	"fmt"
 	"io"
+	"math"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -60,6 +65,24 @@ func TestAggregatorAdd(t *testing.T) {
 			false,
 			2,
 		},
+		{
+			"Second",
+			"2015-08-02 00:03:43",
+			false,
+			1,
+		},
+		{
+			"Week",
+			"2015-W31",
+			false,
+			3,
+		},
+		{
+			"Quarter",
+			"2015-Q3",
+			false,
+			3,
+		},
 		{
 			"NotExisting",
 			"2015-09",
@@ -71,7 +94,7 @@ func TestAggregatorAdd(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			timeRange, _ := indexer.ParseTimeRange(tt.timeRange)
-			idx := aggregator.GetIndex(timeRange)
+			idx, _ := aggregator.GetIndex(timeRange)
 
 			if (idx == nil) != tt.wantNil {
 				t.Fatalf("GetIndexFor(%q) is nil = %v, want nil %v", tt.timeRange, idx == nil, tt.wantNil)
@@ -86,19 +109,107 @@ func TestAggregatorAdd(t *testing.T) {
 	}
 }
 
-func BenchmarkAggregator(b *testing.B) {
-	var traces []indexer.Trace
+func TestAggregatorGetRange(t *testing.T) {
+	aggregator := indexer.NewAggregator()
+	traces := []indexer.Trace{
+		{time.Date(2015, 8, 1, 13, 19, 59, 0, time.UTC), "q0"},
+		{time.Date(2015, 8, 1, 13, 20, 0, 0, time.UTC), "q1"},
+		{time.Date(2015, 8, 2, 10, 0, 0, 0, time.UTC), "q1"},
+		{time.Date(2015, 8, 2, 11, 0, 0, 0, time.UTC), "q2"},
+		{time.Date(2015, 8, 3, 2, 9, 59, 0, time.UTC), "q2"},
+		{time.Date(2015, 8, 3, 2, 10, 0, 0, time.UTC), "q3"},
+	}
+	for _, trace := range traces {
+		aggregator.Add(trace)
+	}
 
-	file, _ := os.Open("testdata/bench_aggr.tsv")
-	defer file.Close()
-	traceReader := indexer.NewTraceReader(file)
-	for trace, err := traceReader.Read(); !errors.Is(err, io.EOF); trace, err = traceReader.Read() {
-		if err != nil {
-			b.Fatalf("Error %v occured", err)
-		}
-		traces = append(traces, trace)
+	tests := []struct {
+		name    string
+		from    time.Time
+		to      time.Time
+		wantNil bool
+		wantErr bool
+		top     []indexer.TopQuery
+	}{
+		{
+			"Range",
+			time.Date(2015, 8, 1, 13, 20, 0, 0, time.UTC),
+			time.Date(2015, 8, 3, 2, 10, 0, 0, time.UTC),
+			false,
+			false,
+			[]indexer.TopQuery{{Query: "q1", Count: 2}, {Query: "q2", Count: 2}},
+		},
+		{
+			"SingleIndex",
+			time.Date(2015, 8, 2, 0, 0, 0, 0, time.UTC),
+			time.Date(2015, 8, 3, 0, 0, 0, 0, time.UTC),
+			false,
+			false,
+			[]indexer.TopQuery{{Query: "q1", Count: 1}, {Query: "q2", Count: 1}},
+		},
+		{
+			"NotExisting",
+			time.Date(2015, 9, 1, 0, 0, 0, 0, time.UTC),
+			time.Date(2015, 9, 3, 0, 0, 0, 0, time.UTC),
+			true,
+			false,
+			nil,
+		},
+		{
+			"Inverted",
+			time.Date(2015, 8, 3, 0, 0, 0, 0, time.UTC),
+			time.Date(2015, 8, 1, 0, 0, 0, 0, time.UTC),
+			true,
+			true,
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idx, err := aggregator.GetRange(tt.from, tt.to)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetRange() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (idx == nil) != tt.wantNil {
+				t.Fatalf("GetRange() is nil = %v, want nil %v", idx == nil, tt.wantNil)
+			} else if idx == nil {
+				return
+			}
+
+			if idx.Len() != len(tt.top) {
+				t.Errorf("GetRange().Len() = %d, want %d", idx.Len(), len(tt.top))
+			}
+			top := idx.Top(len(tt.top))
+			sort.Slice(top, func(i, j int) bool { return top[i].Query < top[j].Query })
+			for i := range tt.top {
+				if top[i] != tt.top[i] {
+					t.Errorf("GetRange().Top() = %v, want %v", top, tt.top)
+					break
+				}
+			}
+		})
 	}
 
+	t.Run("ReadOnly", func(t *testing.T) {
+		// Adding a query to a composed index is ignored and reported.
+		idx, _ := aggregator.GetRange(time.Date(2015, 8, 1, 0, 0, 0, 0, time.UTC), time.Date(2015, 8, 3, 0, 0, 0, 0, time.UTC))
+		idx.Add("q4")
+		if idx.Len() != 3 {
+			t.Errorf("GetRange().Len() = %d after Add, want %d", idx.Len(), 3)
+		}
+		if err := idx.(interface{ Err() error }).Err(); !errors.Is(err, indexer.ErrReadOnly) {
+			t.Errorf("GetRange().Err() = %v after Add, want %v", err, indexer.ErrReadOnly)
+		}
+		if err := indexer.Merge(idx, indexer.NewMemoryIndex()); !errors.Is(err, indexer.ErrReadOnly) {
+			t.Errorf("Merge() error = %v, want %v", err, indexer.ErrReadOnly)
+		}
+	})
+}
+
+func BenchmarkAggregator(b *testing.B) {
+	traces := readBenchTraces(b)
+
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
@@ -114,3 +225,353 @@ func BenchmarkAggregator(b *testing.B) {
 		wg.Wait()
 	}
 }
+
+func BenchmarkAggregatorAddBatch(b *testing.B) {
+	traces := readBenchTraces(b)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		aggregator := indexer.NewAggregator()
+		for j := 0; j < len(traces); j += 1000 {
+			end := j + 1000
+			if end > len(traces) {
+				end = len(traces)
+			}
+			aggregator.AddBatch(traces[j:end])
+		}
+	}
+}
+
+// readBenchTraces reads the traces of benchmarks.
+func readBenchTraces(b *testing.B) []indexer.Trace {
+	var traces []indexer.Trace
+
+	file, _ := os.Open("testdata/bench_aggr.tsv")
+	defer file.Close()
+	traceReader := indexer.NewTraceReader(file)
+	for trace, err := traceReader.Read(); !errors.Is(err, io.EOF); trace, err = traceReader.Read() {
+		if err != nil {
+			b.Fatalf("Error %v occured", err)
+		}
+		traces = append(traces, trace)
+	}
+
+	return traces
+}
+
+func TestAggregatorAddBatch(t *testing.T) {
+	traces := []indexer.Trace{
+		{time.Date(2015, 8, 1, 0, 3, 43, 0, time.UTC), "q1"},
+		{time.Date(2015, 8, 2, 0, 3, 43, 0, time.UTC), "q1"},
+		{time.Date(2015, 8, 2, 0, 3, 44, 0, time.UTC), "q2"},
+		{time.Date(2015, 8, 2, 0, 5, 45, 0, time.UTC), "q1"},
+	}
+	one, batch := indexer.NewAggregator(), indexer.NewAggregator()
+	for _, trace := range traces {
+		one.Add(trace)
+	}
+	batch.AddBatch(traces)
+
+	for _, timeRange := range []string{"2015", "2015-W31", "2015-08-02", "2015-08-02 00:03", "2015-08-02 00:05:45"} {
+		r, _ := indexer.ParseTimeRange(timeRange)
+		want, _ := one.GetIndex(r)
+		got, _ := batch.GetIndex(r)
+		if got == nil {
+			t.Fatalf("GetIndex(%q) is nil", timeRange)
+		}
+		if wantTop, gotTop := fmt.Sprint(want.Top(10)), fmt.Sprint(got.Top(10)); gotTop != wantTop {
+			t.Errorf("GetIndex(%q).Top(10) = %v, want %v", timeRange, gotTop, wantTop)
+		}
+	}
+}
+
+func TestAggregatorApproximateCount(t *testing.T) {
+	aggregator := indexer.NewAggregator(indexer.WithApproximateCount(0.01))
+	traces := []indexer.Trace{
+		{time.Date(2015, 8, 1, 0, 3, 43, 0, time.UTC), "q1"},
+		{time.Date(2015, 8, 1, 0, 3, 43, 0, time.UTC), "q1"},
+		{time.Date(2015, 8, 1, 1, 3, 44, 0, time.UTC), "q2"},
+		{time.Date(2015, 8, 1, 2, 5, 45, 0, time.UTC), "q1"},
+	}
+	for _, trace := range traces {
+		aggregator.Add(trace)
+	}
+
+	r, _ := indexer.ParseTimeRange("2015-08-01")
+	idx, _ := aggregator.GetIndex(r)
+	if _, ok := idx.(indexer.Estimator); !ok {
+		t.Fatalf("GetIndex(%q) is %T, want an Estimator", r, idx)
+	}
+	if idx.Len() != 2 {
+		t.Errorf("GetIndex(%q).Len() = %d, want %d", r, idx.Len(), 2)
+	}
+	if top, want := idx.Top(1), (indexer.TopQuery{Query: "q1", Count: 3}); len(top) != 1 || top[0] != want {
+		t.Errorf("GetIndex(%q).Top(1) = %v, want [%v]", r, top, want)
+	}
+
+	// Estimation stays within 3 standard errors for larger counts.
+	for _, distinct := range []int{100, 10000} {
+		aggregator := indexer.NewAggregator(indexer.WithApproximateCount(0.01))
+		for i := 0; i < 2*distinct; i++ {
+			date := time.Date(2015, 8, 1, 0, i%(24*60), 0, 0, time.UTC)
+			aggregator.Add(indexer.Trace{date, fmt.Sprintf("Query %d", i%distinct)})
+		}
+		idx, _ := aggregator.GetIndex(r)
+		if math.Abs(float64(idx.Len()-distinct)) > 3*0.01*float64(distinct) {
+			t.Errorf("GetIndex(%q).Len() = %d, want %d ± 3%%", r, idx.Len(), distinct)
+		}
+	}
+
+	r, _ = indexer.ParseTimeRange("2015-08-01 01")
+	if idx, _ := aggregator.GetIndex(r); idx == nil {
+		t.Fatalf("GetIndex(%q) is nil", r)
+	} else if _, ok := idx.(indexer.Estimator); ok {
+		t.Errorf("GetIndex(%q) is an Estimator, want an exact index", r)
+	}
+}
+
+func TestAggregatorGetIndexInLocation(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skipf("Time zone not available: %v", err)
+	}
+
+	aggregator := indexer.NewAggregator()
+	traces := []indexer.Trace{
+		// 2015-08-02 00:30 in Paris.
+		{time.Date(2015, 8, 1, 22, 30, 0, 0, time.UTC), "q1"},
+		{time.Date(2015, 8, 2, 21, 59, 0, 0, time.UTC), "q2"},
+		// 2015-08-03 00:00 in Paris.
+		{time.Date(2015, 8, 2, 22, 0, 0, 0, time.UTC), "q3"},
+		// The 25 hours of 2015-10-25 in Paris.
+		{time.Date(2015, 10, 24, 22, 0, 0, 0, time.UTC), "q4"},
+		{time.Date(2015, 10, 25, 22, 59, 0, 0, time.UTC), "q5"},
+		// The 23 hours of 2015-03-29 in Paris.
+		{time.Date(2015, 3, 28, 23, 0, 0, 0, time.UTC), "q6"},
+		{time.Date(2015, 3, 29, 21, 59, 0, 0, time.UTC), "q7"},
+		{time.Date(2015, 3, 29, 22, 0, 0, 0, time.UTC), "q8"},
+		// A trace of another time zone is bucketed in UTC.
+		{time.Date(2015, 8, 1, 23, 45, 0, 0, paris), "q9"},
+	}
+	for _, trace := range traces {
+		aggregator.Add(trace)
+	}
+
+	tests := []struct {
+		name      string
+		timeRange string
+		want      []string
+	}{
+		{"Day", "2015-08-02", []string{"q1", "q2"}},
+		{"Hour", "2015-08-02 00", []string{"q1"}},
+		{"FallBack", "2015-10-25", []string{"q4", "q5"}},
+		{"SpringForward", "2015-03-29", []string{"q6", "q7"}},
+		{"OtherZone", "2015-08-01 23", []string{"q9"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timeRange, err := indexer.ParseTimeRangeIn(tt.timeRange, paris)
+			if err != nil {
+				t.Fatalf("Error %v occured", err)
+			}
+			idx, _ := aggregator.GetIndex(timeRange)
+			if idx == nil {
+				t.Fatalf("GetIndex(%q) is nil", tt.timeRange)
+			}
+
+			var got []string
+			for _, q := range idx.Top(10) {
+				got = append(got, q.Query)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("GetIndex(%q) queries = %v, want %v", tt.timeRange, got, tt.want)
+			}
+		})
+	}
+
+	// In UTC the same day is another one.
+	timeRange, _ := indexer.ParseTimeRange("2015-08-02")
+	if idx, _ := aggregator.GetIndex(timeRange); idx == nil || idx.Len() != 2 {
+		t.Errorf("GetIndex(%q) should contain 2 queries in UTC", "2015-08-02")
+	}
+}
+
+func TestAggregatorWithPrecisions(t *testing.T) {
+	aggregator := indexer.NewAggregator(indexer.WithPrecisions(indexer.Month, indexer.Day))
+	aggregator.Add(indexer.Trace{time.Date(2015, 8, 1, 0, 3, 43, 0, time.UTC), "q1"})
+	aggregator.Add(indexer.Trace{time.Date(2015, 8, 2, 0, 3, 43, 0, time.UTC), "q2"})
+
+	for _, timeRange := range []string{"2015-08", "2015-08-01"} {
+		r, _ := indexer.ParseTimeRange(timeRange)
+		if idx, err := aggregator.GetIndex(r); err != nil || idx == nil {
+			t.Errorf("GetIndex(%q) = %v, %v, want an index", timeRange, idx, err)
+		}
+	}
+	for _, timeRange := range []string{"2015", "2015-W31", "2015-08-01 00"} {
+		r, _ := indexer.ParseTimeRange(timeRange)
+		if _, err := aggregator.GetIndex(r); !errors.Is(err, indexer.ErrPrecisionNotIndexed) {
+			t.Errorf("GetIndex(%q) error = %v, want %v", timeRange, err, indexer.ErrPrecisionNotIndexed)
+		}
+	}
+
+	if idx, err := aggregator.GetRange(time.Date(2015, 7, 31, 0, 0, 0, 0, time.UTC), time.Date(2015, 8, 3, 0, 0, 0, 0, time.UTC)); err != nil || idx.Len() != 2 {
+		t.Errorf("GetRange() of days = %v, %v, want 2 queries", idx, err)
+	}
+	if _, err := aggregator.GetRange(time.Date(2015, 8, 1, 12, 0, 0, 0, time.UTC), time.Date(2015, 8, 3, 0, 0, 0, 0, time.UTC)); !errors.Is(err, indexer.ErrPrecisionNotIndexed) {
+		t.Errorf("GetRange() of hours error = %v, want %v", err, indexer.ErrPrecisionNotIndexed)
+	}
+}
+
+func TestParseTimePrecision(t *testing.T) {
+	for _, precision := range []indexer.TimePrecision{indexer.Year, indexer.Quarter, indexer.Month, indexer.Week, indexer.Day, indexer.Hour, indexer.Minute, indexer.Second} {
+		if got, err := indexer.ParseTimePrecision(precision.String()); err != nil || got != precision {
+			t.Errorf("ParseTimePrecision(%q) = %v, %v, want %v", precision.String(), got, err, precision)
+		}
+	}
+	if _, err := indexer.ParseTimePrecision("decade"); err == nil {
+		t.Errorf("ParseTimePrecision(%q) should fail", "decade")
+	}
+}
+
+func TestAggregatorSeries(t *testing.T) {
+	aggregator := indexer.NewAggregator(indexer.WithNormalizers(indexer.CaseFold))
+	for _, trace := range []indexer.Trace{
+		{time.Date(2015, 8, 1, 0, 3, 43, 0, time.UTC), "q1"},
+		{time.Date(2015, 8, 1, 0, 59, 0, 0, time.UTC), "Q1"},
+		{time.Date(2015, 8, 1, 2, 0, 0, 0, time.UTC), "q1"},
+		{time.Date(2015, 8, 1, 2, 0, 0, 0, time.UTC), "q2"},
+		{time.Date(2015, 8, 2, 0, 0, 0, 0, time.UTC), "q1"},
+	} {
+		aggregator.Add(trace)
+	}
+
+	day, _ := indexer.ParseTimeRange("2015-08-01")
+	points, err := aggregator.Series("Q1", day.Start(), day.End(), indexer.Hour)
+	if err != nil {
+		t.Fatalf("Error %v occured", err)
+	}
+	if len(points) != 24 {
+		t.Fatalf("Series() returns %d points, want %d", len(points), 24)
+	}
+	for i, point := range points {
+		want := 0
+		switch i {
+		case 0:
+			want = 2
+		case 2:
+			want = 1
+		}
+		if point.Count != want || point.Range.String() != fmt.Sprintf("2015-08-01 %02d", i) {
+			t.Errorf("Point %d = %v %d, want 2015-08-01 %02d %d", i, point.Range, point.Count, i, want)
+		}
+	}
+
+	// Coarse indexes are used as well.
+	month, _ := indexer.ParseTimeRange("2015-08")
+	points, err = aggregator.Series("q1", month.Start(), month.End(), indexer.Day)
+	if err != nil || len(points) != 31 || points[0].Count != 3 || points[1].Count != 1 || points[2].Count != 0 {
+		t.Errorf("Series() of days = %v, %v, want 31 points starting with 3, 1, 0", points, err)
+	}
+
+	for _, tt := range []struct {
+		from, to string
+		step     indexer.TimePrecision
+	}{
+		{"2015-08-01", "2015-08-02", indexer.Month},
+		{"2015-08-01 12:30", "2015-08-02", indexer.Hour},
+		{"2015-08", "2015-09", indexer.Week},
+		{"2015", "2016", indexer.Second},
+	} {
+		from, _ := indexer.ParseTimeRange(tt.from)
+		to, _ := indexer.ParseTimeRange(tt.to)
+		if _, err := aggregator.Series("q1", from.Start(), to.Start(), tt.step); !errors.Is(err, indexer.ErrInvalidStep) {
+			t.Errorf("Series(%s, %s, %v) error = %v, want %v", tt.from, tt.to, tt.step, err, indexer.ErrInvalidStep)
+		}
+	}
+}
+
+func TestAggregatorRetention(t *testing.T) {
+	dir := t.TempDir()
+	aggregator := indexer.NewAggregator(
+		indexer.WithPrecisions(indexer.Minute, indexer.Hour, indexer.Day),
+		indexer.WithRetention(indexer.Minute, time.Hour),
+		indexer.WithRetention(indexer.Hour, 24*time.Hour),
+		indexer.WithEvictionInterval(0),
+		indexer.WithDiskIndexes(dir, 1),
+	)
+	defer aggregator.Close()
+
+	aggregator.Add(indexer.Trace{time.Date(2015, 8, 1, 0, 3, 0, 0, time.UTC), "q1"})
+	aggregator.Add(indexer.Trace{time.Date(2015, 8, 1, 1, 3, 0, 0, time.UTC), "q2"})
+	if evicted := aggregator.Evict(); evicted != 0 {
+		t.Fatalf("Evict() = %d, want %d", evicted, 0)
+	}
+
+	// The minute 00:03 ends more than an hour before the watermark.
+	aggregator.Add(indexer.Trace{time.Date(2015, 8, 1, 1, 5, 0, 0, time.UTC), "q3"})
+	if evicted := aggregator.Evict(); evicted != 1 {
+		t.Fatalf("Evict() = %d, want %d", evicted, 1)
+	}
+	for timeRange, want := range map[string]int{"2015-08-01 00:03": 0, "2015-08-01 01:03": 1, "2015-08-01 00": 1, "2015-08-01": 3} {
+		r, _ := indexer.ParseTimeRange(timeRange)
+		idx, err := aggregator.GetIndex(r)
+		if err != nil {
+			t.Fatalf("Error %v occured", err)
+		}
+		got := 0
+		if idx != nil {
+			got = idx.Len()
+		}
+		if got != want {
+			t.Errorf("GetIndex(%q) has %d queries, want %d", timeRange, got, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "2015-08-01T00-03")); !os.IsNotExist(err) {
+		t.Errorf("Files of an evicted index should be removed, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "2015-08-01T01-03")); err != nil {
+		t.Errorf("Files of a kept index should be kept, got %v", err)
+	}
+
+	// Late traces of evicted indexes are ignored.
+	aggregator.Add(indexer.Trace{time.Date(2015, 8, 1, 0, 3, 0, 0, time.UTC), "q1"})
+	r, _ := indexer.ParseTimeRange("2015-08-01 00:03")
+	if idx, _ := aggregator.GetIndex(r); idx != nil {
+		t.Errorf("GetIndex(%v) = %v, want none", r, idx)
+	}
+
+	// A day later only the Day index is kept.
+	aggregator.AddBatch([]indexer.Trace{{time.Date(2015, 8, 2, 2, 0, 0, 0, time.UTC), "q4"}})
+	if evicted := aggregator.Evict(); evicted != 4 {
+		t.Errorf("Evict() = %d, want %d", evicted, 4)
+	}
+}
+
+func TestAggregatorEvictReleasesQueries(t *testing.T) {
+	aggregator := indexer.NewAggregator(
+		indexer.WithPrecisions(indexer.Minute),
+		indexer.WithRetention(indexer.Minute, time.Minute),
+		indexer.WithEvictionInterval(time.Millisecond),
+	)
+	defer aggregator.Close()
+
+	aggregator.Add(indexer.Trace{time.Date(2015, 8, 1, 0, 3, 0, 0, time.UTC), "evicted query"})
+	aggregator.Add(indexer.Trace{time.Date(2015, 8, 1, 0, 5, 0, 0, time.UTC), "kept query"})
+
+	// The evictor runs in background.
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		if _, ok := aggregator.Interner().Lookup("evicted query"); !ok {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("Query of an evicted index should be released")
+		}
+	}
+	r, _ := indexer.ParseTimeRange("2015-08-01 00:03")
+	if idx, _ := aggregator.GetIndex(r); idx != nil {
+		t.Errorf("GetIndex(%v) = %v, want none", r, idx)
+	}
+	if _, ok := aggregator.Interner().Lookup("kept query"); !ok {
+		t.Errorf("Query of a kept index should be kept")
+	}
+}
@@ -0,0 +1,451 @@
+package indexer
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// maxSegments is the number of segment files
+// after which they are merged into a single one.
+const maxSegments = 8
+
+type (
+	// diskIndex indexes data in sorted segment files, only the queries
+	// indexed since the last flush are kept in memory.
+	diskIndex struct {
+		// dir is the directory containing segment files.
+		dir string
+		// head is a map containing queries and their counts
+		// which are not flushed yet.
+		head map[string]int
+		// headSize is the number of distinct queries in head
+		// triggering a flush.
+		headSize int
+		// segments are the paths of segment files,
+		// each one contains queries sorted alphabetically.
+		segments []string
+		// nextSegment is the number of the next segment file.
+		nextSegment int
+		// mux allows to read/write maps and slices in concurrent way.
+		mux sync.RWMutex
+		// err is the last error occurred while reading or writing segments,
+		// head is kept in memory till a flush succeeds.
+		err error
+		// errMux allows to read/write err in concurrent way.
+		errMux sync.Mutex
+	}
+
+	// entrySource iterates over queries sorted alphabetically.
+	entrySource interface {
+		// next returns the next query and its count, ok is false at the end.
+		next() (query string, count int, ok bool, err error)
+	}
+)
+
+// NewDiskIndex creates an instance of diskIndex storing its segment files in dir.
+// headSize is the number of distinct queries kept in memory before being flushed.
+// The directory is created at the first flush, the segment files of a previous
+// instance using the same directory are overwritten.
+func NewDiskIndex(dir string, headSize int) Index {
+	if headSize < 1 {
+		headSize = 1
+	}
+	return &diskIndex{
+		dir:      dir,
+		head:     make(map[string]int),
+		headSize: headSize,
+	}
+}
+
+// Add adds new query to the index.
+func (idx *diskIndex) Add(s string) {
+	idx.mux.Lock()
+	defer idx.mux.Unlock()
+
+	idx.head[s]++
+	if len(idx.head) >= idx.headSize {
+		idx.setErr(idx.flush())
+	}
+}
+
+// AddBatch adds new queries to the index at once.
+func (idx *diskIndex) AddBatch(queries []string) {
+	idx.mux.Lock()
+	defer idx.mux.Unlock()
+
+	for _, s := range queries {
+		idx.head[s]++
+		if len(idx.head) >= idx.headSize {
+			idx.setErr(idx.flush())
+		}
+	}
+}
+
+// Merge adds the queries of src with their counts to the index.
+func (idx *diskIndex) Merge(src Index) error {
+	if src == Index(idx) {
+		return fmt.Errorf("diskIndex.Merge(): an index can't be merged into itself.")
+	}
+	srcQueries := copyQueries(src)
+
+	idx.mux.Lock()
+	defer idx.mux.Unlock()
+
+	for _, q := range srcQueries {
+		idx.head[q.Query] += q.Count
+		if len(idx.head) >= idx.headSize {
+			idx.setErr(idx.flush())
+		}
+	}
+	return idx.Err()
+}
+
+// Subtract subtracts the counts of the queries of src from the ones of the index,
+// queries whose count isn't positive anymore are removed.
+// All the segment files are merged into a single one.
+func (idx *diskIndex) Subtract(src Index) error {
+	if src == Index(idx) {
+		return fmt.Errorf("diskIndex.Subtract(): an index can't be subtracted from itself.")
+	}
+	subtracted := make(map[string]int)
+	src.Range(func(query string, count int) bool {
+		subtracted[query] -= count
+		return true
+	})
+
+	idx.mux.Lock()
+	defer idx.mux.Unlock()
+
+	if err := os.MkdirAll(idx.dir, 0755); err != nil {
+		return fmt.Errorf("diskIndex.Subtract(): %w.", err)
+	}
+	sources, err := idx.sources()
+	if err != nil {
+		return err
+	}
+	path, err := idx.writeSegment(append(sources, newHeadSource(subtracted)))
+	closeSources(sources)
+	if err != nil {
+		return err
+	}
+
+	for _, segment := range idx.segments {
+		os.Remove(segment)
+	}
+	idx.segments = []string{path}
+	idx.head = make(map[string]int)
+	return nil
+}
+
+// Len gets the count of distinct indexed queries.
+func (idx *diskIndex) Len() int {
+	var result int
+	idx.Range(func(string, int) bool {
+		result++
+		return true
+	})
+	return result
+}
+
+// Top returns most popular queries.
+func (idx *diskIndex) Top(size int) []TopQuery {
+	// Keep the most popular queries in a min-heap.
+	h := &topHeap{}
+	idx.Range(func(query string, count int) bool {
+		if h.Len() < size {
+			heap.Push(h, TopQuery{Query: query, Count: count})
+		} else if size > 0 && (*h)[0].Count < count {
+			(*h)[0] = TopQuery{Query: query, Count: count}
+			heap.Fix(h, 0)
+		}
+		return true
+	})
+
+	result := make([]TopQuery, h.Len())
+	for i := len(result) - 1; i >= 0; i-- {
+		result[i] = heap.Pop(h).(TopQuery)
+	}
+	return result
+}
+
+// Range calls f for each indexed query and its count
+// while f returns true. Queries are sorted alphabetically.
+// If a segment file can't be read, the iteration stops and Err reports it.
+func (idx *diskIndex) Range(f func(query string, count int) bool) {
+	idx.mux.RLock()
+	defer idx.mux.RUnlock()
+
+	sources, err := idx.sources()
+	if err == nil {
+		err = mergeSources(sources, f)
+	}
+	closeSources(sources)
+
+	if err != nil {
+		idx.setErr(err)
+	}
+}
+
+// Flush writes the queries of head to a segment file.
+func (idx *diskIndex) Flush() error {
+	idx.mux.Lock()
+	defer idx.mux.Unlock()
+
+	if len(idx.head) == 0 {
+		return nil
+	}
+	err := idx.flush()
+	idx.setErr(err)
+	return err
+}
+
+// Close implements io.Closer interface, head is flushed
+// and the index could still be read.
+func (idx *diskIndex) Close() error {
+	return idx.Flush()
+}
+
+// Remove removes the segment files and their directory.
+func (idx *diskIndex) Remove() error {
+	idx.mux.Lock()
+	defer idx.mux.Unlock()
+
+	idx.head = make(map[string]int)
+	idx.segments = nil
+	if err := os.RemoveAll(idx.dir); err != nil {
+		return fmt.Errorf("diskIndex.Remove(): %w.", err)
+	}
+	return nil
+}
+
+// Err returns the last error occurred while reading or writing segment files.
+func (idx *diskIndex) Err() error {
+	idx.errMux.Lock()
+	defer idx.errMux.Unlock()
+
+	return idx.err
+}
+
+// setErr keeps the last error occurred.
+func (idx *diskIndex) setErr(err error) {
+	idx.errMux.Lock()
+	defer idx.errMux.Unlock()
+
+	idx.err = err
+}
+
+// flush writes head to a new segment file and merges
+// the segment files if there are too much of them.
+func (idx *diskIndex) flush() error {
+	if err := os.MkdirAll(idx.dir, 0755); err != nil {
+		return fmt.Errorf("diskIndex.flush(): %w.", err)
+	}
+
+	path, err := idx.writeSegment([]entrySource{newHeadSource(idx.head)})
+	if err != nil {
+		return err
+	}
+	idx.segments = append(idx.segments, path)
+	idx.head = make(map[string]int)
+
+	if len(idx.segments) < maxSegments {
+		return nil
+	}
+
+	// Merge all the segments into a single one.
+	sources, err := idx.sources()
+	if err == nil {
+		path, err = idx.writeSegment(sources)
+	}
+	closeSources(sources)
+	if err != nil {
+		return err
+	}
+
+	for _, segment := range idx.segments {
+		os.Remove(segment)
+	}
+	idx.segments = []string{path}
+	return nil
+}
+
+// writeSegment writes a new segment file merging given sources,
+// queries whose count isn't positive are skipped.
+func (idx *diskIndex) writeSegment(sources []entrySource) (string, error) {
+	path := filepath.Join(idx.dir, fmt.Sprintf("%06d.seg", idx.nextSegment))
+	idx.nextSegment++
+
+	file, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("diskIndex.writeSegment(): %w.", err)
+	}
+	w := bufio.NewWriter(file)
+
+	var buf [binary.MaxVarintLen64]byte
+	err = mergeSources(sources, func(query string, count int) bool {
+		if count <= 0 {
+			return true
+		}
+		w.Write(buf[:binary.PutUvarint(buf[:], uint64(len(query)))])
+		w.WriteString(query)
+		_, err := w.Write(buf[:binary.PutUvarint(buf[:], uint64(count))])
+		return err == nil
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", fmt.Errorf("diskIndex.writeSegment(): %w.", err)
+	}
+	return path, nil
+}
+
+// sources opens all the segment files and the head.
+func (idx *diskIndex) sources() ([]entrySource, error) {
+	sources := []entrySource{newHeadSource(idx.head)}
+	for _, path := range idx.segments {
+		file, err := os.Open(path)
+		if err != nil {
+			closeSources(sources)
+			return nil, fmt.Errorf("diskIndex.sources(): %w.", err)
+		}
+		sources = append(sources, &segmentSource{file: file, r: bufio.NewReader(file)})
+	}
+	return sources, nil
+}
+
+// mergeSources calls f for each query of given sources sorted alphabetically
+// while f returns true. Counts of a query found in several sources are summed.
+func mergeSources(sources []entrySource, f func(query string, count int) bool) error {
+	type current struct {
+		query string
+		count int
+		ok    bool
+	}
+
+	// Read the first entry of each source.
+	currents := make([]current, len(sources))
+	for i, source := range sources {
+		query, count, ok, err := source.next()
+		if err != nil {
+			return err
+		}
+		currents[i] = current{query, count, ok}
+	}
+
+	for {
+		// Find the smallest query among the sources.
+		var query string
+		found := false
+		for _, c := range currents {
+			if c.ok && (!found || c.query < query) {
+				query, found = c.query, true
+			}
+		}
+		if !found {
+			return nil
+		}
+
+		// Sum its counts and move the concerned sources forward.
+		var count int
+		for i := range currents {
+			if currents[i].ok && currents[i].query == query {
+				count += currents[i].count
+				q, c, ok, err := sources[i].next()
+				if err != nil {
+					return err
+				}
+				currents[i] = current{q, c, ok}
+			}
+		}
+
+		if !f(query, count) {
+			return nil
+		}
+	}
+}
+
+// closeSources closes the segment files of given sources.
+func closeSources(sources []entrySource) {
+	for _, source := range sources {
+		if s, ok := source.(*segmentSource); ok {
+			s.file.Close()
+		}
+	}
+}
+
+// headSource iterates over the queries of a head sorted alphabetically.
+type headSource struct {
+	head    map[string]int
+	queries []string
+}
+
+// newHeadSource creates an instance of headSource.
+func newHeadSource(head map[string]int) *headSource {
+	queries := make([]string, 0, len(head))
+	for query := range head {
+		queries = append(queries, query)
+	}
+	sort.Strings(queries)
+	return &headSource{head: head, queries: queries}
+}
+
+func (s *headSource) next() (string, int, bool, error) {
+	if len(s.queries) == 0 {
+		return "", 0, false, nil
+	}
+	query := s.queries[0]
+	s.queries = s.queries[1:]
+	return query, s.head[query], true, nil
+}
+
+// segmentSource iterates over the queries of a segment file.
+type segmentSource struct {
+	file *os.File
+	r    *bufio.Reader
+}
+
+func (s *segmentSource) next() (string, int, bool, error) {
+	n, err := binary.ReadUvarint(s.r)
+	if err == io.EOF {
+		return "", 0, false, nil
+	} else if err != nil {
+		return "", 0, false, fmt.Errorf("segmentSource.next(): %w.", err)
+	}
+
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(s.r, buf); err != nil {
+		return "", 0, false, fmt.Errorf("segmentSource.next(): %w.", err)
+	}
+
+	count, err := binary.ReadUvarint(s.r)
+	if err != nil {
+		return "", 0, false, fmt.Errorf("segmentSource.next(): %w.", err)
+	}
+	return string(buf), int(count), true, nil
+}
+
+// topHeap is a min-heap of TopQuery by their counts.
+type topHeap []TopQuery
+
+func (h topHeap) Len() int            { return len(h) }
+func (h topHeap) Less(i, j int) bool  { return h[i].Count < h[j].Count }
+func (h topHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *topHeap) Push(x interface{}) { *h = append(*h, x.(TopQuery)) }
+func (h *topHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
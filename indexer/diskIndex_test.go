@@ -0,0 +1,105 @@
+package indexer_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cosaques/algolia/indexer"
+)
+
+func TestDiskIndexAdd(t *testing.T) {
+	// A small head makes the index flush and merge its segments.
+	idx := indexer.NewDiskIndex(t.TempDir(), 3)
+	index(idx, 10, 10)
+	if idx.Len() != 10 {
+		t.Fatalf("Index len = %d, want %d (%T)", idx.Len(), 10, idx)
+	}
+
+	if err := idx.(interface{ Err() error }).Err(); err != nil {
+		t.Fatalf("Error %v occured", err)
+	}
+}
+
+func TestDiskIndexTop(t *testing.T) {
+	idx := indexer.NewDiskIndex(t.TempDir(), 3)
+	index(idx, 10, 10)
+	tops := idx.Top(3)
+	if len(tops) != 3 {
+		t.Fatalf("Top len = %d, want %d (%T)", len(tops), 3, idx)
+	}
+	for i, top := range tops {
+		want := indexer.TopQuery{Query: fmt.Sprintf("Query %d", 10-i), Count: 10 * (10 - i)}
+		if top != want {
+			t.Errorf("Top %d = %v, want %v (%T)", i+1, top, want, idx)
+		}
+	}
+}
+
+func TestDiskIndexClose(t *testing.T) {
+	dir := t.TempDir()
+	idx := indexer.NewDiskIndex(dir, 100)
+	index(idx, 10, 10)
+	if matches, _ := filepath.Glob(filepath.Join(dir, "*.seg")); len(matches) != 0 {
+		t.Fatalf("Segment files %v found before Close", matches)
+	}
+
+	// The head is flushed, the index could still be read.
+	if err := idx.Close(); err != nil {
+		t.Fatalf("Error %v occured", err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "*.seg")); len(matches) != 1 {
+		t.Fatalf("Segment files %v found after Close, want 1", matches)
+	}
+	if idx.Len() != 10 {
+		t.Errorf("Index len = %d after Close, want %d (%T)", idx.Len(), 10, idx)
+	}
+}
+
+func TestAggregatorWithDiskIndexes(t *testing.T) {
+	dir := t.TempDir()
+	aggregator := indexer.NewAggregator(indexer.WithDiskIndexes(dir, 1))
+	traces := []indexer.Trace{
+		{time.Date(2015, 8, 1, 0, 3, 43, 0, time.UTC), "q1"},
+		{time.Date(2015, 8, 2, 0, 3, 43, 0, time.UTC), "q1"},
+		{time.Date(2015, 8, 2, 0, 3, 44, 0, time.UTC), "q2"},
+	}
+	for _, trace := range traces {
+		aggregator.Add(trace)
+	}
+
+	r, _ := indexer.ParseTimeRange("2015-08-02 00:03")
+	if idx, _ := aggregator.GetIndex(r); idx.Len() != 2 {
+		t.Fatalf("GetIndex(%q).Len() = %d, want %d", r, idx.Len(), 2)
+	}
+
+	if matches, _ := filepath.Glob(filepath.Join(dir, "2015-08-02T00-03", "*.seg")); len(matches) == 0 {
+		t.Fatalf("No segment files found for %q", r)
+	}
+}
+
+func TestAggregatorDiskIndexesFlushSealed(t *testing.T) {
+	dir := t.TempDir()
+	aggregator := indexer.NewAggregator(indexer.WithDiskIndexes(dir, 100000), indexer.WithPrecisions(indexer.Hour, indexer.Minute))
+	aggregator.AddBatch([]indexer.Trace{
+		{time.Date(2015, 8, 1, 0, 3, 43, 0, time.UTC), "q1"},
+		{time.Date(2015, 8, 1, 0, 3, 44, 0, time.UTC), "q2"},
+	})
+	aggregator.Add(indexer.Trace{time.Date(2015, 8, 1, 0, 5, 0, 0, time.UTC), "q1"})
+
+	// Only the indexes whose TimeRange is over are flushed.
+	for rangeDir, flushed := range map[string]bool{"2015-08-01T00-03": true, "2015-08-01T00-05": false, "2015-08-01T00": false} {
+		if matches, _ := filepath.Glob(filepath.Join(dir, rangeDir, "*.seg")); (len(matches) > 0) != flushed {
+			t.Errorf("Segment files of %q = %v, want flushed %v", rangeDir, matches, flushed)
+		}
+	}
+
+	// Closing the aggregator flushes the others.
+	if err := aggregator.Close(); err != nil {
+		t.Fatalf("Error %v occured", err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "2015-08-01T00", "*.seg")); len(matches) == 0 {
+		t.Errorf("No segment files found for %q after Close", "2015-08-01T00")
+	}
+}
@@ -0,0 +1,138 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// followReader reads a growing file like `tail -F` does: at the end of the file
+// it waits for new data, rereads the file if it's truncated and reopens it
+// if it's rotated (i.e. replaced by a new file with the same path).
+type followReader struct {
+	filePath string
+	// file is the currently read file.
+	file *os.File
+	// offset is the position of the next read in file.
+	offset int64
+	// interval is the periodicity of file checks at its end.
+	interval time.Duration
+	// ctx stops the waits for new data once it's done.
+	ctx context.Context
+	// mux allows to close the file while it's read.
+	mux sync.Mutex
+	// closed is closed once the reader is closed.
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// FollowFile opens a log file of queries and keeps reading it as it grows.
+// Its Read never returns io.EOF till it's closed or ctx is done.
+func FollowFile(ctx context.Context, filePath string, interval time.Duration) (io.ReadCloser, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("FollowFile: %w.", err)
+	}
+
+	return &followReader{
+		filePath: filePath,
+		file:     file,
+		interval: interval,
+		ctx:      ctx,
+		closed:   make(chan struct{}),
+	}, nil
+}
+
+// Read implements io.Reader interface.
+func (r *followReader) Read(p []byte) (int, error) {
+	for {
+		if n, err := r.read(p); n > 0 || err != nil {
+			return n, err
+		}
+
+		// Wait for new data.
+		select {
+		case <-r.closed:
+			return 0, io.EOF
+		case <-r.ctx.Done():
+			return 0, io.EOF
+		case <-time.After(r.interval):
+		}
+	}
+}
+
+// read reads the file, at its end it checks if the file is rotated or truncated.
+func (r *followReader) read(p []byte) (int, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	select {
+	case <-r.closed:
+		return 0, io.EOF
+	default:
+	}
+
+	n, err := r.file.Read(p)
+	r.offset += int64(n)
+	if n > 0 {
+		return n, nil
+	}
+	if err != nil && err != io.EOF {
+		return 0, fmt.Errorf("followReader.read(): %w.", err)
+	}
+
+	// The end of the file is reached.
+	return 0, r.check()
+}
+
+// check reopens the file if it's rotated or rewinds it if it's truncated.
+func (r *followReader) check() error {
+	info, err := os.Stat(r.filePath)
+	if os.IsNotExist(err) {
+		// The file may be being rotated.
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("followReader.check(): %w.", err)
+	}
+
+	current, err := r.file.Stat()
+	if err != nil {
+		return fmt.Errorf("followReader.check(): %w.", err)
+	}
+
+	switch {
+	case !os.SameFile(info, current):
+		// The file is rotated, so the new one is read from its beginning.
+		file, err := os.Open(r.filePath)
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("followReader.check(): %w.", err)
+		}
+		r.file.Close()
+		r.file, r.offset = file, 0
+	case info.Size() < r.offset:
+		// The file is truncated, so it's reread from its beginning.
+		if _, err := r.file.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("followReader.check(): %w.", err)
+		}
+		r.offset = 0
+	}
+	return nil
+}
+
+// Close implements io.Closer interface.
+func (r *followReader) Close() error {
+	var err error
+	r.closeOnce.Do(func() {
+		close(r.closed)
+
+		r.mux.Lock()
+		defer r.mux.Unlock()
+		err = r.file.Close()
+	})
+	return err
+}
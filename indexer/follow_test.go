@@ -0,0 +1,127 @@
+package indexer_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cosaques/algolia/indexer"
+)
+
+func TestFollowFile(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "logs.tsv")
+	writeLines := func(flag int, queries ...string) {
+		file, err := os.OpenFile(filePath, flag|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatalf("Error %v occured", err)
+		}
+		defer file.Close()
+		for _, query := range queries {
+			fmt.Fprintf(file, "2015-08-01 00:03:43\t%s\n", query)
+		}
+	}
+	writeLines(os.O_CREATE, "q1", "q2")
+
+	r, err := indexer.FollowFile(context.Background(), filePath, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Error %v occured", err)
+	}
+
+	// Read traces in parallel till the reader is closed.
+	queries := make(chan string)
+	go func() {
+		defer close(queries)
+		traceReader := indexer.NewTraceReader(r)
+		for trace, err := traceReader.Read(); !errors.Is(err, io.EOF); trace, err = traceReader.Read() {
+			if err != nil {
+				t.Errorf("Error %v occured", err)
+				return
+			}
+			queries <- trace.Query
+		}
+	}()
+	expect := func(want ...string) {
+		t.Helper()
+		for _, w := range want {
+			select {
+			case got := <-queries:
+				if got != w {
+					t.Fatalf("Read query %q, want %q", got, w)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("Query %q not read", w)
+			}
+		}
+	}
+
+	expect("q1", "q2")
+
+	t.Run("Append", func(t *testing.T) {
+		writeLines(os.O_APPEND, "q3")
+		expect("q3")
+	})
+
+	t.Run("Truncate", func(t *testing.T) {
+		writeLines(os.O_TRUNC, "q4")
+		expect("q4")
+	})
+
+	t.Run("Rotate", func(t *testing.T) {
+		if err := os.Rename(filePath, filePath+".1"); err != nil {
+			t.Fatalf("Error %v occured", err)
+		}
+		writeLines(os.O_CREATE, "q5")
+		expect("q5")
+	})
+
+	r.Close()
+	select {
+	case _, ok := <-queries:
+		if ok {
+			t.Fatalf("Query read after Close")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Reading not stopped by Close")
+	}
+}
+
+func TestFollowFileContext(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "logs.tsv")
+	if err := os.WriteFile(filePath, []byte("2015-08-01 00:03:43\tq1\n"), 0644); err != nil {
+		t.Fatalf("Error %v occured", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r, err := indexer.FollowFile(ctx, filePath, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Error %v occured", err)
+	}
+	defer r.Close()
+	traceReader := indexer.NewContextTraceReader(ctx, indexer.NewTraceReader(r))
+
+	if trace, err := traceReader.Read(); err != nil || trace.Query != "q1" {
+		t.Fatalf("Read (%v, %v), want q1", trace, err)
+	}
+
+	// The wait for new data is stopped by the cancellation.
+	errs := make(chan error)
+	go func() {
+		_, err := traceReader.Read()
+		errs <- err
+	}()
+	cancel()
+	select {
+	case err := <-errs:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Read error %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Reading not stopped by the cancellation")
+	}
+}
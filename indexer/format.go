@@ -0,0 +1,211 @@
+package indexer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type (
+	// ReaderOptions describes the format of a log file of queries.
+	ReaderOptions struct {
+		// Format is the name of a registered format: "tsv", "csv", "jsonl"...
+		Format string
+		// DateColumn and QueryColumn are the positions of fields
+		// in a line of a tsv or a csv file, starting from 0.
+		DateColumn  int
+		QueryColumn int
+		// Columns is the expected number of fields in a line
+		// of a tsv or a csv file, any number is allowed if 0.
+		Columns int
+		// DateField and QueryField are the names of fields in a JSON line.
+		DateField  string
+		QueryField string
+		// TimeLayout is either a layout of time.Parse or
+		// "rfc3339", "unix" (seconds) or "unixms" (milliseconds).
+		TimeLayout string
+	}
+
+	// TraceReaderFactory creates a TraceReader of a given format.
+	TraceReaderFactory func(io.Reader, ReaderOptions) TraceReader
+)
+
+// DefaultReaderOptions describes the tsv log files of queries
+// containing a date and a query on each line.
+var DefaultReaderOptions = ReaderOptions{
+	Format:      "tsv",
+	DateColumn:  0,
+	QueryColumn: 1,
+	Columns:     2,
+	DateField:   "date",
+	QueryField:  "query",
+	TimeLayout:  "2006-01-02 15:04:05",
+}
+
+var (
+	// formats is a map of registered formats.
+	formats = map[string]TraceReaderFactory{
+		"tsv": func(r io.Reader, options ReaderOptions) TraceReader {
+			return newCSVTraceReader(r, '\t', options)
+		},
+		"csv": func(r io.Reader, options ReaderOptions) TraceReader {
+			return newCSVTraceReader(r, ',', options)
+		},
+		"jsonl": newJSONLinesTraceReader,
+	}
+	// formatsMux allows to read/write formats in concurrent way.
+	formatsMux sync.RWMutex
+)
+
+// RegisterTraceFormat makes a format of log files available
+// by its name in ReaderOptions.Format.
+func RegisterTraceFormat(name string, factory TraceReaderFactory) {
+	formatsMux.Lock()
+	defer formatsMux.Unlock()
+
+	formats[name] = factory
+}
+
+// TraceFormats returns the names of registered formats.
+func TraceFormats() []string {
+	formatsMux.RLock()
+	defer formatsMux.RUnlock()
+
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewTraceReaderWith creates a TraceReader of the format described by options.
+func NewTraceReaderWith(r io.Reader, options ReaderOptions) (TraceReader, error) {
+	formatsMux.RLock()
+	factory, exists := formats[options.Format]
+	formatsMux.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("NewTraceReaderWith: unknown format %q.", options.Format)
+	}
+	return factory(r, options), nil
+}
+
+// RegisterFlags defines the flags setting options in a given flag set,
+// their default values are the current options.
+func (o *ReaderOptions) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.Format, "format", o.Format, fmt.Sprintf("The format of logs, one of %v", TraceFormats()))
+	fs.IntVar(&o.DateColumn, "date-col", o.DateColumn, "The position of the date in a tsv or csv line, starting from 0")
+	fs.IntVar(&o.QueryColumn, "query-col", o.QueryColumn, "The position of the query in a tsv or csv line, starting from 0")
+	fs.IntVar(&o.Columns, "cols", o.Columns, "The number of fields of a tsv or csv line, any if 0")
+	fs.StringVar(&o.DateField, "date-field", o.DateField, "The name of the date field in a JSON line")
+	fs.StringVar(&o.QueryField, "query-field", o.QueryField, "The name of the query field in a JSON line")
+	fs.StringVar(&o.TimeLayout, "time-layout", o.TimeLayout, "The layout of dates: a Go time layout, \"rfc3339\", \"unix\" or \"unixms\"")
+}
+
+// parseRecord parses the fields of a line in a tsv or a csv file.
+func (o ReaderOptions) parseRecord(fields []string) (Trace, error) {
+	if o.Columns > 0 && len(fields) != o.Columns {
+		return Trace{}, fmt.Errorf("line should contain %d args %v", o.Columns, fields)
+	}
+	if len(fields) <= o.DateColumn || len(fields) <= o.QueryColumn {
+		return Trace{}, fmt.Errorf("line should contain the date at %d and the query at %d %v", o.DateColumn, o.QueryColumn, fields)
+	}
+
+	// Parse date.
+	date, err := o.parseDate(fields[o.DateColumn])
+	if err != nil {
+		return Trace{}, err
+	}
+
+	// Construct a Trace.
+	return Trace{Date: date, Query: fields[o.QueryColumn]}, nil
+}
+
+// parseDate parses a date according to TimeLayout, dates are returned in UTC.
+func (o ReaderOptions) parseDate(value string) (time.Time, error) {
+	switch o.TimeLayout {
+	case "rfc3339":
+		date, err := time.Parse(time.RFC3339, value)
+		return date.UTC(), err
+	case "unix", "unixms":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("parsing time %q: %w", value, err)
+		}
+		if o.TimeLayout == "unix" {
+			return time.Unix(n, 0).UTC(), nil
+		}
+		return time.Unix(0, n*int64(time.Millisecond)).UTC(), nil
+	default:
+		date, err := time.Parse(o.TimeLayout, value)
+		return date.UTC(), err
+	}
+}
+
+// jsonLinesTraceReader reads traces from a file containing a JSON object on each line.
+type jsonLinesTraceReader struct {
+	scanner *bufio.Scanner
+	options ReaderOptions
+	// line is the number of the last read line.
+	line int
+}
+
+// newJSONLinesTraceReader creates a new instance of jsonLinesTraceReader.
+func newJSONLinesTraceReader(r io.Reader, options ReaderOptions) TraceReader {
+	scanner := bufio.NewScanner(r)
+	// Lines may contain more fields than the date and the query.
+	scanner.Buffer(nil, 1024*1024)
+	return &jsonLinesTraceReader{scanner: scanner, options: options}
+}
+
+// Read reads the traces one by one.
+func (t *jsonLinesTraceReader) Read() (Trace, error) {
+	// Skip empty lines.
+	var line []byte
+	for len(line) == 0 {
+		if !t.scanner.Scan() {
+			if err := t.scanner.Err(); err != nil {
+				return Trace{}, fmt.Errorf("traceReader.Read(): %w.", err)
+			}
+			return Trace{}, fmt.Errorf("traceReader.Read(): %w.", io.EOF)
+		}
+		t.line++
+		line = bytes.TrimSpace(t.scanner.Bytes())
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.UseNumber()
+	var object map[string]interface{}
+	if err := decoder.Decode(&object); err != nil {
+		return Trace{}, &TraceError{Line: t.line, Record: string(line), Err: err}
+	}
+
+	// Dates could be either strings or numbers (unix times).
+	var date string
+	switch v := object[t.options.DateField].(type) {
+	case string:
+		date = v
+	case json.Number:
+		date = v.String()
+	default:
+		return Trace{}, &TraceError{Line: t.line, Record: string(line), Err: fmt.Errorf("field %q should be a date", t.options.DateField)}
+	}
+	query, ok := object[t.options.QueryField].(string)
+	if !ok {
+		return Trace{}, &TraceError{Line: t.line, Record: string(line), Err: fmt.Errorf("field %q should be a string", t.options.QueryField)}
+	}
+
+	parsedDate, err := t.options.parseDate(date)
+	if err != nil {
+		return Trace{}, &TraceError{Line: t.line, Record: string(line), Err: err}
+	}
+	return Trace{Date: parsedDate, Query: query}, nil
+}
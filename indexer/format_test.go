@@ -0,0 +1,142 @@
+package indexer_test
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cosaques/algolia/indexer"
+)
+
+func TestNewTraceReaderWith(t *testing.T) {
+	want := []indexer.Trace{
+		{time.Date(2015, 8, 1, 0, 4, 0, 0, time.UTC), "q1"},
+		{time.Date(2015, 8, 1, 0, 4, 1, 0, time.UTC), "q2"},
+	}
+
+	tests := []struct {
+		name    string
+		input   string
+		options indexer.ReaderOptions
+	}{
+		{
+			"Tsv",
+			"2015-08-01 00:04:00\tq1\n2015-08-01 00:04:01\tq2\n",
+			indexer.DefaultReaderOptions,
+		},
+		{
+			"CsvColumns",
+			"42,fr,q1,2015-08-01T02:04:00+02:00,10\n43,en,q2,2015-08-01T00:04:01Z,0\n",
+			indexer.ReaderOptions{Format: "csv", DateColumn: 3, QueryColumn: 2, TimeLayout: "rfc3339"},
+		},
+		{
+			"JsonLines",
+			`{"user": 42, "ts": 1438387440, "q": "q1"}` + "\n\n" + `{"user": 43, "ts": 1438387441, "q": "q2"}`,
+			indexer.ReaderOptions{Format: "jsonl", DateField: "ts", QueryField: "q", TimeLayout: "unix"},
+		},
+		{
+			"JsonLinesMillis",
+			`{"date": "1438387440000", "query": "q1"}` + "\n" + `{"date": 1438387441000, "query": "q2"}`,
+			indexer.ReaderOptions{Format: "jsonl", DateField: "date", QueryField: "query", TimeLayout: "unixms"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			traceReader, err := indexer.NewTraceReaderWith(strings.NewReader(tt.input), tt.options)
+			if err != nil {
+				t.Fatalf("Error %v occured", err)
+			}
+
+			for i := range want {
+				got, err := traceReader.Read()
+				if err != nil {
+					t.Fatalf("Error %v occured", err)
+				}
+				if !got.Date.Equal(want[i].Date) || got.Query != want[i].Query || got.Date.Location() != time.UTC {
+					t.Fatalf("Get trace %v, want %v", got, want[i])
+				}
+			}
+
+			if _, err := traceReader.Read(); !errors.Is(err, io.EOF) {
+				t.Fatalf("Get error %v, want EOF", err)
+			}
+		})
+	}
+}
+
+func TestNewTraceReaderWithErrors(t *testing.T) {
+	t.Run("UnknownFormat", func(t *testing.T) {
+		if _, err := indexer.NewTraceReaderWith(strings.NewReader(""), indexer.ReaderOptions{Format: "xml"}); err == nil {
+			t.Fatalf("NewTraceReaderWith() error is nil, want error")
+		}
+	})
+
+	t.Run("JsonLines", func(t *testing.T) {
+		input := `{"date": "2015-08-01 00:04:00", "query": "q1"}` + "\n" +
+			`{"date": "2015-08-01 00:04:00"}` + "\n" +
+			`not json` + "\n" +
+			`{"date": "2015-08-01 00:04:02", "query": "q3"}`
+		options := indexer.DefaultReaderOptions
+		options.Format = "jsonl"
+		traceReader, _ := indexer.NewTraceReaderWith(strings.NewReader(input), options)
+
+		var lines []int
+		for trace, err := traceReader.Read(); !errors.Is(err, io.EOF); trace, err = traceReader.Read() {
+			var traceErr *indexer.TraceError
+			if errors.As(err, &traceErr) {
+				lines = append(lines, traceErr.Line)
+			} else if err != nil {
+				t.Fatalf("Error %v occured", err)
+			} else if trace.Query == "" {
+				t.Fatalf("Get trace %v without query", trace)
+			}
+		}
+		if len(lines) != 2 || lines[0] != 2 || lines[1] != 3 {
+			t.Fatalf("Errors at lines %v, want %v", lines, []int{2, 3})
+		}
+	})
+}
+
+func TestRegisterTraceFormat(t *testing.T) {
+	indexer.RegisterTraceFormat("queries", func(r io.Reader, options indexer.ReaderOptions) indexer.TraceReader {
+		return &queriesReader{lines: strings.Split(readAll(r), "\n")}
+	})
+
+	found := false
+	for _, format := range indexer.TraceFormats() {
+		found = found || format == "queries"
+	}
+	if !found {
+		t.Fatalf("TraceFormats() = %v, want %q in it", indexer.TraceFormats(), "queries")
+	}
+
+	traceReader, err := indexer.NewTraceReaderWith(strings.NewReader("q1"), indexer.ReaderOptions{Format: "queries"})
+	if err != nil {
+		t.Fatalf("Error %v occured", err)
+	}
+	if trace, err := traceReader.Read(); err != nil || trace.Query != "q1" {
+		t.Fatalf("Get trace %v, error %v, want %q", trace, err, "q1")
+	}
+}
+
+// queriesReader reads a query per line without date.
+type queriesReader struct {
+	lines []string
+}
+
+func (r *queriesReader) Read() (indexer.Trace, error) {
+	if len(r.lines) == 0 {
+		return indexer.Trace{}, io.EOF
+	}
+	query := r.lines[0]
+	r.lines = r.lines[1:]
+	return indexer.Trace{Query: query}, nil
+}
+
+func readAll(r io.Reader) string {
+	var sb strings.Builder
+	io.Copy(&sb, r)
+	return sb.String()
+}
@@ -0,0 +1,92 @@
+package indexer
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+// hyperLogLog estimates the number of distinct strings
+// using a fixed amount of memory.
+type hyperLogLog struct {
+	// p is the number of bits of a hash selecting a register.
+	p uint8
+	// registers keep the maximum rank observed by each register.
+	registers []uint8
+}
+
+// newHyperLogLog creates an instance of hyperLogLog
+// whose standard error is at most errorRate (from 0.4% to 26%).
+func newHyperLogLog(errorRate float64) *hyperLogLog {
+	// Standard error is 1.04 / sqrt(2^p).
+	p := uint8(4)
+	for p < 16 && 1.04/math.Sqrt(float64(uint64(1)<<p)) > errorRate {
+		p++
+	}
+	return &hyperLogLog{p: p, registers: make([]uint8, 1<<p)}
+}
+
+// add adds a string to the estimation.
+func (h *hyperLogLog) add(s string) {
+	x := hashString(s)
+	i := x >> (64 - h.p)
+	// Rank is the position of the first 1 bit in the remaining bits.
+	rank := uint8(bits.LeadingZeros64(x<<h.p|1<<(h.p-1))) + 1
+	if rank > h.registers[i] {
+		h.registers[i] = rank
+	}
+}
+
+// count returns the estimated number of distinct strings.
+func (h *hyperLogLog) count() int {
+	m := float64(len(h.registers))
+
+	var sum float64
+	var zeros int
+	for _, r := range h.registers {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	var alpha float64
+	switch len(h.registers) {
+	case 16:
+		alpha = 0.673
+	case 32:
+		alpha = 0.697
+	case 64:
+		alpha = 0.709
+	default:
+		alpha = 0.7213 / (1 + 1.079/m)
+	}
+	estimate := alpha * m * m / sum
+
+	// Small ranges are better estimated by linear counting.
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+
+	return int(estimate + 0.5)
+}
+
+// errorRate returns the standard error of the estimation.
+func (h *hyperLogLog) errorRate() float64 {
+	return 1.04 / math.Sqrt(float64(len(h.registers)))
+}
+
+// hashString hashes a string into 64 well distributed bits.
+func hashString(s string) uint64 {
+	f := fnv.New64a()
+	f.Write([]byte(s))
+	x := f.Sum64()
+
+	// FNV doesn't spread close strings enough, so mix its bits (splitmix64 finalizer).
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
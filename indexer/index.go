@@ -1,6 +1,8 @@
 package indexer
 
 import (
+	"fmt"
+	"io"
 	"sort"
 	"sync"
 )
@@ -14,45 +16,119 @@ type (
 		Len() int
 		// Top returns most popular queries.
 		Top(int) []TopQuery
+		// Range calls f for each indexed query and its count
+		// while f returns true.
+		Range(f func(query string, count int) bool)
+		// Close stops the indexation, the index could still be read
+		// but no query should be added anymore.
+		io.Closer
+	}
+
+	// BatchAdder is implemented by indexes adding several queries at once
+	// faster than one by one.
+	BatchAdder interface {
+		// AddBatch adds new queries to the index.
+		AddBatch([]string)
+	}
+
+	// QueryCounter is implemented by indexes getting the count of a query
+	// faster than by ranging over all of them.
+	QueryCounter interface {
+		// Count returns the count of a query, 0 if it isn't indexed.
+		Count(query string) int
+	}
+
+	// Merger is implemented by indexes adding the queries of another index
+	// with their counts faster than one by one.
+	Merger interface {
+		// Merge adds the queries of src with their counts to the index.
+		Merge(src Index) error
+	}
+
+	// Subtracter is implemented by indexes whose counts could be decreased.
+	Subtracter interface {
+		// Subtract subtracts the counts of the queries of src from the ones of the index,
+		// queries whose count isn't positive anymore are removed.
+		Subtract(src Index) error
+	}
+
+	// Flusher is implemented by indexes keeping their latest queries in memory
+	// before storing them.
+	Flusher interface {
+		// Flush stores the queries kept in memory.
+		Flush() error
+	}
+
+	// Remover is implemented by indexes storing their queries outside of memory.
+	Remover interface {
+		// Remove removes the stored queries, the index shouldn't be used anymore.
+		Remove() error
+	}
+
+	// Estimator is implemented by indexes whose Len is an estimation.
+	Estimator interface {
+		// ErrorRate returns the standard error of Len.
+		ErrorRate() float64
 	}
 
 	// TopQuery represents response of Index.Top().
 	TopQuery struct {
 		Query string
 		Count int
+		// Error is the maximum overestimation of Count,
+		// it is 0 for exact indexes.
+		Error int
 	}
 )
 
 type (
 	// memoryIndex indexes data and stores it in memory.
 	memoryIndex struct {
-		// counts is a map containing distinct queries and their counts,
+		// positions is a map containing distinct queries and their positions in order,
 		// note that we operate with references to strings to optimize the memory usage.
-		counts map[*string]int
-		// order keeps order of map keys according to its counts.
+		positions map[*string]int
+		// order keeps queries ordered by their counts, the most popular first.
 		order []*string
+		// counts are the counts of the queries of order.
+		counts []int
+		// blocks are the positions in order of the queries having a given count,
+		// so that an incremented query is moved to the next block in O(1).
+		blocks map[int]block
+		// catalog finds queries for Search, it is built by the first search.
+		catalog *queryCatalog
+		// interner caches the queries, the index references each of its queries once.
+		interner *Interner
 		// mux allows to read/write maps and slices in concurrent way.
 		mux sync.RWMutex
 		// toIndex stores queries to be indexed.
 		toIndex chan indexArgs
+		// closed tells if the index is closed, then queries aren't indexed anymore.
+		closed bool
+		// closeMux allows to close toIndex while queries are sent to it.
+		closeMux sync.RWMutex
+	}
+
+	// block is the range of positions [first, last] of queries having the same count.
+	block struct {
+		first, last int
 	}
 
-	// indexArgs allows to track the completion of query's indexation.
+	// indexArgs allows to track the completion of queries' indexation.
 	indexArgs struct {
-		s         *string
+		queries   []*string
 		completed chan<- bool
 	}
 )
 
 // NewMemoryIndex creates an instance of memoryIndex
 func NewMemoryIndex() Index {
-	idx := &memoryIndex{
-		counts: make(map[*string]int),
-		mux:    sync.RWMutex{},
-		// toIndex is a buffered channel that allows to check
-		// if there are other queries waiting to be indexed.
-		toIndex: make(chan indexArgs, 1),
-	}
+	return NewMemoryIndexWithInterner(NewInterner())
+}
+
+// NewMemoryIndexWithInterner creates an instance of memoryIndex caching its queries
+// with a given Interner, so that they share their memory with other indexes.
+func NewMemoryIndexWithInterner(interner *Interner) Index {
+	idx := newMemoryIndex(interner)
 
 	// Run indexation in parallel.
 	go idx.run()
@@ -60,21 +136,79 @@ func NewMemoryIndex() Index {
 	return idx
 }
 
+// newMemoryIndex creates an instance of memoryIndex without running its indexation.
+func newMemoryIndex(interner *Interner) *memoryIndex {
+	return &memoryIndex{
+		interner:  interner,
+		positions: make(map[*string]int),
+		blocks:    make(map[int]block),
+		mux:       sync.RWMutex{},
+		// toIndex is a buffered channel that allows to check
+		// if there are other queries waiting to be indexed.
+		toIndex: make(chan indexArgs, 1),
+	}
+}
+
 // Add adds new query to the index.
 func (idx *memoryIndex) Add(s string) {
+	idx.closeMux.RLock()
+	defer idx.closeMux.RUnlock()
+	if idx.closed {
+		return
+	}
+
+	completed := make(chan bool)
+	idx.toIndex <- indexArgs{[]*string{idx.interner.Acquire(s)}, completed}
+
+	// Wait the end of indexation.
+	<-completed
+}
+
+// AddBatch adds new queries to the index at once.
+func (idx *memoryIndex) AddBatch(queries []string) {
+	idx.closeMux.RLock()
+	defer idx.closeMux.RUnlock()
+	if idx.closed {
+		return
+	}
+
+	ptrs := make([]*string, len(queries))
+	for i, s := range queries {
+		ptrs[i] = idx.interner.Acquire(s)
+	}
+
 	completed := make(chan bool)
-	idx.toIndex <- indexArgs{LoadOrStoreStringPtr(s), completed}
+	idx.toIndex <- indexArgs{ptrs, completed}
 
 	// Wait the end of indexation.
 	<-completed
 }
 
+// Close stops the indexation and releases the interned queries, the index
+// could still be read but its queries aren't indexed anymore.
+func (idx *memoryIndex) Close() error {
+	idx.closeMux.Lock()
+	defer idx.closeMux.Unlock()
+	if idx.closed {
+		return nil
+	}
+	idx.closed = true
+	close(idx.toIndex)
+
+	idx.mux.RLock()
+	defer idx.mux.RUnlock()
+	for _, s := range idx.order {
+		idx.interner.Release(s)
+	}
+	return nil
+}
+
 // Len gets the count of distinct indexed queries.
 func (idx *memoryIndex) Len() int {
 	idx.mux.RLock()
 	defer idx.mux.RUnlock()
 
-	return len(idx.counts)
+	return len(idx.positions)
 }
 
 // Top returns most popular queries.
@@ -83,39 +217,152 @@ func (idx *memoryIndex) Top(size int) []TopQuery {
 	defer idx.mux.RUnlock()
 
 	// Check that asked size is less than current count of distinct queries.
-	if size > len(idx.order) {
+	if size < 0 {
+		size = 0
+	} else if size > len(idx.order) {
 		size = len(idx.order)
 	}
 	result := make([]TopQuery, size)
 
 	// Create response.
 	for i := 0; i < size; i++ {
-		result[i] = TopQuery{*idx.order[i], idx.counts[idx.order[i]]}
+		result[i] = TopQuery{Query: *idx.order[i], Count: idx.counts[i]}
 	}
 
 	return result
 }
 
+// Range calls f for each indexed query and its count
+// while f returns true.
+func (idx *memoryIndex) Range(f func(query string, count int) bool) {
+	idx.mux.RLock()
+	defer idx.mux.RUnlock()
+
+	for i, s := range idx.order {
+		if !f(*s, idx.counts[i]) {
+			return
+		}
+	}
+}
+
+// Merge adds the queries of src with their counts to the index,
+// which is then ordered again.
+func (idx *memoryIndex) Merge(src Index) error {
+	idx.closeMux.RLock()
+	defer idx.closeMux.RUnlock()
+	if idx.closed {
+		return fmt.Errorf("memoryIndex.Merge(): index closed.")
+	}
+	if src == Index(idx) {
+		return fmt.Errorf("memoryIndex.Merge(): an index can't be merged into itself.")
+	}
+	srcQueries := copyQueries(src)
+
+	// The queries of src are referenced till they are referenced by the index.
+	var acquired []*string
+	defer func() {
+		for _, s := range acquired {
+			idx.interner.Release(s)
+		}
+	}()
+
+	idx.mux.Lock()
+	defer idx.mux.Unlock()
+
+	counts := make(map[*string]int, len(idx.order))
+	queries := make([]*string, len(idx.order))
+	copy(queries, idx.order)
+	for i, s := range idx.order {
+		counts[s] = idx.counts[i]
+	}
+	for _, q := range srcQueries {
+		s := idx.interner.Acquire(q.Query)
+		acquired = append(acquired, s)
+		if _, exists := counts[s]; !exists {
+			queries = append(queries, s)
+		}
+		counts[s] += q.Count
+	}
+	idx.setCounts(queries, counts)
+	return nil
+}
+
+// Subtract subtracts the counts of the queries of src from the ones of the index,
+// queries whose count isn't positive anymore are removed.
+func (idx *memoryIndex) Subtract(src Index) error {
+	idx.closeMux.RLock()
+	defer idx.closeMux.RUnlock()
+	if idx.closed {
+		return fmt.Errorf("memoryIndex.Subtract(): index closed.")
+	}
+	if src == Index(idx) {
+		return fmt.Errorf("memoryIndex.Subtract(): an index can't be subtracted from itself.")
+	}
+	srcQueries := copyQueries(src)
+
+	idx.mux.Lock()
+	defer idx.mux.Unlock()
+
+	counts := make(map[*string]int, len(idx.order))
+	queries := make([]*string, len(idx.order))
+	copy(queries, idx.order)
+	for i, s := range idx.order {
+		counts[s] = idx.counts[i]
+	}
+	for _, q := range srcQueries {
+		if s, exists := idx.interner.Lookup(q.Query); exists {
+			if _, indexed := counts[s]; indexed {
+				counts[s] -= q.Count
+				if counts[s] <= 0 {
+					delete(counts, s)
+				}
+			}
+		}
+	}
+	idx.setCounts(queries, counts)
+	return nil
+}
+
+// Count returns the count of a query, 0 if it isn't indexed.
+func (idx *memoryIndex) Count(query string) int {
+	s, exists := idx.interner.Lookup(query)
+	if !exists {
+		return 0
+	}
+
+	idx.mux.RLock()
+	defer idx.mux.RUnlock()
+
+	if i, exists := idx.positions[s]; exists {
+		return idx.counts[i]
+	}
+	return 0
+}
+
+// Search returns the queries starting with prefix and containing contains,
+// the most popular first, at most size of them (all of them if size < 0).
+func (idx *memoryIndex) Search(prefix, contains string, size int) []TopQuery {
+	// The catalog is updated by searches.
+	idx.mux.Lock()
+	defer idx.mux.Unlock()
+
+	if idx.catalog == nil {
+		idx.catalog = newQueryCatalog(idx.order)
+	}
+
+	var result []TopQuery
+	idx.catalog.find(prefix, contains, func(s *string) {
+		result = append(result, TopQuery{Query: *s, Count: idx.counts[idx.positions[s]]})
+	})
+	return sortTopQueries(result, size)
+}
+
 // run is listening the channel for new queries to be indexed and index them.
 func (idx *memoryIndex) run() {
 	for indexArgs := range idx.toIndex {
-		s := indexArgs.s
 		idx.mux.Lock()
-		{
-			// Add new query.
-			if _, exists := idx.counts[s]; !exists {
-				idx.order = append(idx.order, s)
-			}
-			// Increment query's count
-			idx.counts[s]++
-
-			// If no other queries wait for indexation -
-			// order the queries by their counts.
-			if len(idx.toIndex) == 0 {
-				sort.Slice(idx.order, func(i, j int) bool {
-					return idx.counts[idx.order[i]] > idx.counts[idx.order[j]]
-				})
-			}
+		for _, s := range indexArgs.queries {
+			idx.increment(s)
 		}
 		idx.mux.Unlock()
 
@@ -123,3 +370,166 @@ func (idx *memoryIndex) run() {
 		indexArgs.completed <- true
 	}
 }
+
+// increment increments the count of a query keeping the order,
+// the query is moved to the first position of its block which then
+// becomes the last position of the next block.
+// The index keeps a single reference to each cached query.
+func (idx *memoryIndex) increment(s *string) {
+	i, exists := idx.positions[s]
+	if exists {
+		idx.interner.Release(s)
+	} else {
+		// Add new query with a count of 0, the lowest one.
+		i = len(idx.order)
+		idx.positions[s] = i
+		idx.order = append(idx.order, s)
+		idx.counts = append(idx.counts, 0)
+		idx.blocks[0] = block{i, i}
+		if idx.catalog != nil {
+			idx.catalog.add(s)
+		}
+	}
+
+	count := idx.counts[i]
+	b := idx.blocks[count]
+	j := b.first
+	idx.order[i], idx.order[j] = idx.order[j], idx.order[i]
+	idx.positions[idx.order[i]], idx.positions[idx.order[j]] = i, j
+	idx.counts[j] = count + 1
+
+	if b.last == j {
+		delete(idx.blocks, count)
+	} else {
+		idx.blocks[count] = block{j + 1, b.last}
+	}
+	if next, exists := idx.blocks[count+1]; exists {
+		idx.blocks[count+1] = block{next.first, j}
+	} else {
+		idx.blocks[count+1] = block{j, j}
+	}
+}
+
+// setCounts replaces the queries of the index by given ones with their counts,
+// they have to be referenced strings of its Interner which are then referenced by the index.
+// queries are the keys of counts, queries with equal counts keep their order in it,
+// the ones which aren't in counts are skipped.
+func (idx *memoryIndex) setCounts(queries []*string, counts map[*string]int) {
+	// Queries are referenced before being released, so that they can't be swept meanwhile.
+	for s := range counts {
+		idx.interner.Acquire(*s)
+	}
+	for _, s := range idx.order {
+		idx.interner.Release(s)
+	}
+
+	idx.positions = make(map[*string]int, len(counts))
+	idx.order = make([]*string, 0, len(counts))
+	idx.counts = make([]int, 0, len(counts))
+	idx.blocks = make(map[int]block)
+	idx.catalog = nil
+
+	for _, s := range queries {
+		if _, exists := counts[s]; exists {
+			idx.order = append(idx.order, s)
+		}
+	}
+	sort.SliceStable(idx.order, func(i, j int) bool {
+		return counts[idx.order[i]] > counts[idx.order[j]]
+	})
+
+	for i, s := range idx.order {
+		count := counts[s]
+		idx.positions[s] = i
+		idx.counts = append(idx.counts, count)
+		if b, exists := idx.blocks[count]; exists {
+			idx.blocks[count] = block{b.first, i}
+		} else {
+			idx.blocks[count] = block{i, i}
+		}
+	}
+}
+
+// addBatch adds queries to an index at once if it's a BatchAdder,
+// one by one otherwise.
+func addBatch(idx Index, queries []string) {
+	if batchAdder, ok := idx.(BatchAdder); ok {
+		batchAdder.AddBatch(queries)
+		return
+	}
+	for _, s := range queries {
+		idx.Add(s)
+	}
+}
+
+// dropIndex closes an index and removes its data if it's a Remover.
+func dropIndex(idx Index) error {
+	if err := idx.Close(); err != nil {
+		return err
+	}
+	if remover, ok := idx.(Remover); ok {
+		return remover.Remove()
+	}
+	return nil
+}
+
+// copyQueries returns the queries of an index with their counts, and their errors for a topKIndex,
+// so that they could be added to another index without holding the locks of both.
+func copyQueries(idx Index) []TopQuery {
+	if topK, ok := idx.(*topKIndex); ok {
+		return topK.queries()
+	}
+
+	var result []TopQuery
+	idx.Range(func(query string, count int) bool {
+		result = append(result, TopQuery{Query: query, Count: count})
+		return true
+	})
+	return result
+}
+
+// Merge adds the queries of src with their counts to dst
+// if it's a Merger, one by one otherwise.
+func Merge(dst, src Index) error {
+	if merger, ok := dst.(Merger); ok {
+		return merger.Merge(src)
+	}
+
+	src.Range(func(query string, count int) bool {
+		queries := make([]string, count)
+		for i := range queries {
+			queries[i] = query
+		}
+		addBatch(dst, queries)
+		return true
+	})
+	return nil
+}
+
+// Subtract subtracts the counts of the queries of src from the ones of dst,
+// queries whose count isn't positive anymore are removed.
+// It fails if dst isn't a Subtracter.
+func Subtract(dst, src Index) error {
+	if subtracter, ok := dst.(Subtracter); ok {
+		return subtracter.Subtract(src)
+	}
+	return fmt.Errorf("Subtract: %T can't subtract queries.", dst)
+}
+
+// Count returns the count of a query in an index, 0 if it isn't indexed.
+// Indexes which aren't QueryCounters are ranged over.
+func Count(idx Index, query string) int {
+	if counter, ok := idx.(QueryCounter); ok {
+		return counter.Count(query)
+	}
+
+	result := 0
+	idx.Range(func(s string, count int) bool {
+		if s != query {
+			return true
+		}
+		result = count
+		return false
+	})
+	return result
+}
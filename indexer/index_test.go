@@ -3,9 +3,11 @@ package indexer_test
 import (
 	"bufio"
 	"fmt"
+	"math/rand"
 	"os"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/cosaques/algolia/indexer"
 )
@@ -23,13 +25,136 @@ func TestIndexTop(t *testing.T) {
 	index(idx, 10, 10)
 	tops := idx.Top(3)
 	for i, top := range tops {
-		want := indexer.TopQuery{fmt.Sprintf("Query %d", 10-i), 10 * (10 - i)}
+		want := indexer.TopQuery{Query: fmt.Sprintf("Query %d", 10-i), Count: 10 * (10 - i)}
 		if top != want {
 			t.Errorf("Top %d = %v, want %v (%T)", i+1, top, want, idx)
 		}
 	}
 }
 
+func TestIndexTopNegativeSize(t *testing.T) {
+	for _, idx := range []indexer.Index{indexer.NewMemoryIndex(), indexer.NewTopKIndex(10, 0.01), indexer.NewDiskIndex(t.TempDir(), 3)} {
+		index(idx, 10, 10)
+		if tops := idx.Top(-1); len(tops) != 0 {
+			t.Errorf("Top(-1) = %v, want no queries (%T)", tops, idx)
+		}
+		idx.Close()
+	}
+
+	// A union of indexes and an approximate index read their queries from finer indexes.
+	aggregator := indexer.NewAggregator(indexer.WithApproximateCount(0.01))
+	defer aggregator.Close()
+	aggregator.Add(indexer.Trace{Date: time.Date(2015, 8, 1, 0, 3, 43, 0, time.UTC), Query: "q1"})
+	aggregator.Add(indexer.Trace{Date: time.Date(2015, 8, 2, 0, 3, 43, 0, time.UTC), Query: "q2"})
+	union, _ := aggregator.GetRange(time.Date(2015, 8, 1, 0, 0, 0, 0, time.UTC), time.Date(2015, 8, 3, 0, 0, 0, 0, time.UTC))
+	r, _ := indexer.ParseTimeRange("2015-08-01")
+	sketch, _ := aggregator.GetIndex(r)
+	for _, idx := range []indexer.Index{union, sketch} {
+		if tops := idx.Top(-1); len(tops) != 0 {
+			t.Errorf("Top(-1) = %v, want no queries (%T)", tops, idx)
+		}
+	}
+}
+
+func TestIndexOrder(t *testing.T) {
+	idx := indexer.NewMemoryIndex()
+	want := make(map[string]int)
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		// A few queries are much more popular than the others.
+		query := fmt.Sprintf("Query %d", random.Intn(1+random.Intn(500)))
+		want[query]++
+		if i%3 == 0 {
+			idx.(indexer.BatchAdder).AddBatch([]string{query})
+		} else {
+			idx.Add(query)
+		}
+	}
+
+	tops := idx.Top(len(want))
+	if len(tops) != len(want) {
+		t.Fatalf("Top(%d) returns %d queries", len(want), len(tops))
+	}
+	for i, top := range tops {
+		if top.Count != want[top.Query] {
+			t.Errorf("Top %d = %v, want count %d", i+1, top, want[top.Query])
+		}
+		if i > 0 && tops[i-1].Count < top.Count {
+			t.Errorf("Top %d = %v is after %v", i+1, top, tops[i-1])
+		}
+	}
+}
+
+// indexOnly hides the optional methods of an index.
+type indexOnly struct {
+	indexer.Index
+}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name string
+		dst  indexer.Index
+	}{
+		{"Memory", indexer.NewMemoryIndex()},
+		{"Disk", indexer.NewDiskIndex(t.TempDir(), 3)},
+		{"Fallback", indexOnly{indexer.NewMemoryIndex()}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Query i occurs 10*i times in dst and i times in src.
+			index(tt.dst, 5, 10)
+			src := indexer.NewMemoryIndex()
+			index(src, 10, 1)
+
+			if err := indexer.Merge(tt.dst, src); err != nil {
+				t.Fatalf("Error %v occured", err)
+			}
+			if tt.dst.Len() != 10 {
+				t.Errorf("Index len = %d, want %d", tt.dst.Len(), 10)
+			}
+			for i := 1; i <= 10; i++ {
+				query, want := fmt.Sprintf("Query %d", i), i
+				if i <= 5 {
+					want += 10 * i
+				}
+				if got := indexer.Count(tt.dst, query); got != want {
+					t.Errorf("Count(%q) = %d, want %d", query, got, want)
+				}
+			}
+			if top := tt.dst.Top(1); len(top) != 1 || top[0] != (indexer.TopQuery{Query: "Query 5", Count: 55}) {
+				t.Errorf("Top(1) = %v, want %v", top, "Query 5")
+			}
+			if _, ok := tt.dst.(indexOnly); !ok {
+				if err := indexer.Merge(tt.dst, tt.dst); err == nil {
+					t.Errorf("Merge() of an index into itself should fail")
+				}
+			}
+		})
+	}
+
+	t.Run("Crossed", func(t *testing.T) {
+		// Indexes merged into each other at the same time don't wait for each other.
+		a, b := indexer.NewMemoryIndex(), indexer.NewMemoryIndex()
+		index(a, 100, 1)
+		index(b, 100, 1)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 10; i++ {
+				indexer.Merge(a, b)
+			}
+		}()
+		for i := 0; i < 10; i++ {
+			indexer.Merge(b, a)
+		}
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Crossed merges are deadlocked")
+		}
+	})
+}
+
 func BenchmarkIndex(b *testing.B) {
 	var queries []string
 
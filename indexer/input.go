@@ -0,0 +1,82 @@
+package indexer
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Magic bytes of supported compression formats.
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// OpenTraceFile opens a log file of queries, "-" stands for the standard input.
+// Gzip and zstd compressed files are decompressed transparently.
+func OpenTraceFile(filePath string) (io.ReadCloser, error) {
+	if filePath == "-" {
+		r, err := Decompress(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("OpenTraceFile: %w.", err)
+		}
+		return r, nil
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("OpenTraceFile: %w.", err)
+	}
+
+	r, err := Decompress(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("OpenTraceFile: %w.", err)
+	}
+	return &fileReader{ReadCloser: r, file: file}, nil
+}
+
+// Decompress detects if r is compressed with gzip or zstd by its magic bytes
+// and decompresses it, otherwise r is read as is.
+func Decompress(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	// A shorter input is neither gzip nor zstd.
+	magic, _ := br.Peek(len(zstdMagic))
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gzipReader, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("Decompress: %w.", err)
+		}
+		return gzipReader, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		zstdReader, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("Decompress: %w.", err)
+		}
+		return zstdReader.IOReadCloser(), nil
+	default:
+		return io.NopCloser(br), nil
+	}
+}
+
+// fileReader closes both a decompressing reader and its file.
+type fileReader struct {
+	io.ReadCloser
+	file *os.File
+}
+
+// Close implements io.Closer interface.
+func (r *fileReader) Close() error {
+	err := r.ReadCloser.Close()
+	if fileErr := r.file.Close(); err == nil {
+		err = fileErr
+	}
+	return err
+}
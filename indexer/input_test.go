@@ -0,0 +1,87 @@
+package indexer_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cosaques/algolia/indexer"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestDecompress(t *testing.T) {
+	plain, _ := ioutil.ReadFile("testdata/trace.tsv")
+
+	var gzipped bytes.Buffer
+	gzipWriter := gzip.NewWriter(&gzipped)
+	gzipWriter.Write(plain)
+	gzipWriter.Close()
+
+	var zstded bytes.Buffer
+	zstdWriter, _ := zstd.NewWriter(&zstded)
+	zstdWriter.Write(plain)
+	zstdWriter.Close()
+
+	tests := []struct {
+		name  string
+		input []byte
+		want  []byte
+	}{
+		{"Plain", plain, plain},
+		{"Gzip", gzipped.Bytes(), plain},
+		{"Zstd", zstded.Bytes(), plain},
+		{"Empty", []byte{}, []byte{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := indexer.Decompress(bytes.NewReader(tt.input))
+			if err != nil {
+				t.Fatalf("Error %v occured", err)
+			}
+			defer r.Close()
+
+			got, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("Error %v occured", err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Fatalf("Decompress() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenTraceFile(t *testing.T) {
+	plain, _ := ioutil.ReadFile("testdata/trace.tsv")
+	path := filepath.Join(t.TempDir(), "trace.tsv.gz")
+	file, _ := os.Create(path)
+	gzipWriter := gzip.NewWriter(file)
+	gzipWriter.Write(plain)
+	gzipWriter.Close()
+	file.Close()
+
+	r, err := indexer.OpenTraceFile(path)
+	if err != nil {
+		t.Fatalf("Error %v occured", err)
+	}
+	defer r.Close()
+
+	traceReader := indexer.NewTraceReader(r)
+	for i := 0; i < 3; i++ {
+		if _, err := traceReader.Read(); err != nil {
+			t.Fatalf("Error %v occured", err)
+		}
+	}
+	if _, err := traceReader.Read(); !errors.Is(err, io.EOF) {
+		t.Fatalf("Get error %v, want EOF", err)
+	}
+
+	if _, err := indexer.OpenTraceFile(filepath.Join(t.TempDir(), "missing.tsv")); err == nil {
+		t.Fatalf("OpenTraceFile() error is nil, want error")
+	}
+}
@@ -0,0 +1,124 @@
+package indexer
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Interner caches strings, so that the queries of several indexes share
+// their memory and are compared by their pointers. Strings are reference counted,
+// they are removed by Sweep once they aren't referenced anymore.
+type Interner struct {
+	// strings are the interned strings.
+	strings map[string]*internedString
+	// swept is the number of strings removed by sweeps.
+	swept int
+	// mux allows to read/write strings in concurrent way,
+	// references are counted atomically while it's read-locked.
+	mux sync.RWMutex
+}
+
+type (
+	// internedString is an interned string with the number of references to it.
+	internedString struct {
+		s    string
+		refs int32
+	}
+
+	// InternerStats are memory statistics of an Interner.
+	InternerStats struct {
+		// Strings is the number of interned strings.
+		Strings int
+		// Bytes is the total length of interned strings.
+		Bytes int
+		// References is the number of references to interned strings.
+		References int
+		// Unreferenced is the number of strings removed by the next sweep.
+		Unreferenced int
+		// Swept is the number of strings removed by sweeps.
+		Swept int
+	}
+)
+
+// NewInterner creates an instance of Interner.
+func NewInterner() *Interner {
+	return &Interner{strings: make(map[string]*internedString)}
+}
+
+// Acquire returns the interned string s, interning it if needed, and references it,
+// so that it isn't removed till Release is called.
+func (in *Interner) Acquire(s string) *string {
+	in.mux.RLock()
+	if is, exist := in.strings[s]; exist {
+		atomic.AddInt32(&is.refs, 1)
+		in.mux.RUnlock()
+		return &is.s
+	}
+	in.mux.RUnlock()
+
+	// The string wasn't found, so we'll create it.
+	in.mux.Lock()
+	defer in.mux.Unlock()
+	is, exist := in.strings[s]
+	if !exist {
+		is = &internedString{s: s}
+		in.strings[s] = is
+	}
+	is.refs++
+	return &is.s
+}
+
+// Release removes a reference to a string returned by Acquire.
+func (in *Interner) Release(p *string) {
+	in.mux.RLock()
+	defer in.mux.RUnlock()
+
+	if is, exist := in.strings[*p]; exist && &is.s == p {
+		atomic.AddInt32(&is.refs, -1)
+	}
+}
+
+// Lookup returns the interned string s if any, without referencing it.
+func (in *Interner) Lookup(s string) (*string, bool) {
+	in.mux.RLock()
+	defer in.mux.RUnlock()
+
+	if is, exist := in.strings[s]; exist {
+		return &is.s, true
+	}
+	return nil, false
+}
+
+// Sweep removes the strings which aren't referenced anymore,
+// and returns their number.
+func (in *Interner) Sweep() int {
+	in.mux.Lock()
+	defer in.mux.Unlock()
+
+	swept := 0
+	for s, is := range in.strings {
+		if is.refs <= 0 {
+			delete(in.strings, s)
+			swept++
+		}
+	}
+	in.swept += swept
+	return swept
+}
+
+// Stats returns the memory statistics of the Interner.
+func (in *Interner) Stats() InternerStats {
+	in.mux.RLock()
+	defer in.mux.RUnlock()
+
+	stats := InternerStats{Strings: len(in.strings), Swept: in.swept}
+	for s, is := range in.strings {
+		stats.Bytes += len(s)
+		refs := int(atomic.LoadInt32(&is.refs))
+		stats.References += refs
+		if refs <= 0 {
+			stats.Unreferenced++
+		}
+	}
+	return stats
+}
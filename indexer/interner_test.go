@@ -0,0 +1,91 @@
+package indexer_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cosaques/algolia/indexer"
+)
+
+func TestInternerAcquire(t *testing.T) {
+	interner := indexer.NewInterner()
+	p1 := interner.Acquire("query1")
+	p2 := interner.Acquire("query1")
+	p3 := interner.Acquire("query2")
+
+	if p1 != p2 {
+		t.Fatalf("Different pointers for same strings.")
+	}
+
+	if *p1 != "query1" || *p3 != "query2" {
+		t.Fatalf("Actual %q, %q, want %q, %q", *p1, *p3, "query1", "query2")
+	}
+}
+
+func TestInternerSweep(t *testing.T) {
+	interner := indexer.NewInterner()
+	p1 := interner.Acquire("query1")
+	interner.Acquire("query1")
+	p2 := interner.Acquire("query2")
+
+	interner.Release(p1)
+	interner.Release(p2)
+	want := indexer.InternerStats{Strings: 2, Bytes: 12, References: 1, Unreferenced: 1}
+	if stats := interner.Stats(); stats != want {
+		t.Errorf("Stats() = %+v, want %+v", stats, want)
+	}
+
+	if swept := interner.Sweep(); swept != 1 {
+		t.Errorf("Sweep() = %d, want %d", swept, 1)
+	}
+	if p, ok := interner.Lookup("query1"); !ok || p != p1 {
+		t.Errorf("Lookup(%q) = %v, %v, want %v", "query1", p, ok, p1)
+	}
+	if _, ok := interner.Lookup("query2"); ok {
+		t.Errorf("Lookup(%q) should fail once swept", "query2")
+	}
+	want = indexer.InternerStats{Strings: 1, Bytes: 6, References: 1, Swept: 1}
+	if stats := interner.Stats(); stats != want {
+		t.Errorf("Stats() = %+v, want %+v", stats, want)
+	}
+}
+
+func TestInternerSharedByIndexes(t *testing.T) {
+	interner := indexer.NewInterner()
+	idx1 := indexer.NewMemoryIndexWithInterner(interner)
+	idx2 := indexer.NewMemoryIndexWithInterner(interner)
+	idx1.Add("query1")
+	idx1.Add("query1")
+	idx2.Add("query1")
+	idx2.Add("query2")
+
+	// Each index references its queries once.
+	if stats := interner.Stats(); stats.Strings != 2 || stats.References != 3 {
+		t.Errorf("Stats() = %+v, want 2 strings referenced 3 times", stats)
+	}
+
+	idx2.Close()
+	interner.Sweep()
+	if _, ok := interner.Lookup("query2"); ok {
+		t.Errorf("Lookup(%q) should fail once its index is closed", "query2")
+	}
+	if _, ok := interner.Lookup("query1"); !ok {
+		t.Errorf("Lookup(%q) should succeed while an index references it", "query1")
+	}
+}
+
+func BenchmarkInternerStore(b *testing.B) {
+	interner := indexer.NewInterner()
+	for i := 0; i < b.N; i++ {
+		s := fmt.Sprintf("Query %d", i)
+		interner.Acquire(s)
+	}
+}
+
+func BenchmarkInternerLoad(b *testing.B) {
+	interner := indexer.NewInterner()
+	interner.Acquire("Query")
+	for i := 0; i < b.N; i++ {
+		interner.Acquire("Query")
+	}
+}
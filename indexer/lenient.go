@@ -0,0 +1,103 @@
+package indexer
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrorPolicy defines how lines of a log file which can't be read are handled.
+type ErrorPolicy int
+
+const (
+	// FailOnError returns the error of a line.
+	FailOnError ErrorPolicy = iota
+	// SkipOnError skips the line.
+	SkipOnError
+	// RecordOnError skips the line and records it to a dead-letter file.
+	RecordOnError
+)
+
+// ParseErrorPolicy parses an ErrorPolicy from "fail", "skip" or "record".
+func ParseErrorPolicy(value string) (ErrorPolicy, error) {
+	switch value {
+	case "fail":
+		return FailOnError, nil
+	case "skip":
+		return SkipOnError, nil
+	case "record":
+		return RecordOnError, nil
+	default:
+		return FailOnError, fmt.Errorf("ParseErrorPolicy: Unknown error policy %q.", value)
+	}
+}
+
+// lenientTraceReader reads traces handling lines which can't be read
+// according to an ErrorPolicy.
+type lenientTraceReader struct {
+	traceReader TraceReader
+	policy      ErrorPolicy
+	// deadLetter records the skipped lines, their number and the reason.
+	deadLetter *csv.Writer
+	// mux allows to write deadLetter in concurrent way.
+	mux sync.Mutex
+	// onReject is called for each skipped line.
+	onReject func(*TraceError)
+}
+
+// NewLenientTraceReader wraps a TraceReader handling lines it can't read (TraceError)
+// according to a given policy. With RecordOnError, rejected lines are written to
+// deadLetter as tsv lines: line number, reason and content. onReject, if not nil,
+// is called for each rejected line. Errors other than TraceError are always returned.
+func NewLenientTraceReader(traceReader TraceReader, policy ErrorPolicy, deadLetter io.Writer, onReject func(*TraceError)) TraceReader {
+	r := &lenientTraceReader{
+		traceReader: traceReader,
+		policy:      policy,
+		onReject:    onReject,
+	}
+	if policy == RecordOnError {
+		r.deadLetter = csv.NewWriter(deadLetter)
+		r.deadLetter.Comma = '\t'
+	}
+	return r
+}
+
+// Read reads the traces one by one.
+func (r *lenientTraceReader) Read() (Trace, error) {
+	for {
+		trace, err := r.traceReader.Read()
+
+		var traceErr *TraceError
+		if r.policy == FailOnError || !errors.As(err, &traceErr) {
+			return trace, err
+		}
+
+		if r.onReject != nil {
+			r.onReject(traceErr)
+		}
+		if r.policy == RecordOnError {
+			if err := r.record(traceErr); err != nil {
+				return Trace{}, err
+			}
+		}
+	}
+}
+
+// record writes a rejected line to the dead-letter file.
+func (r *lenientTraceReader) record(traceErr *TraceError) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	// Keep the reason on a single line.
+	reason := strings.Join(strings.Fields(traceErr.Err.Error()), " ")
+	r.deadLetter.Write([]string{strconv.Itoa(traceErr.Line), reason, traceErr.Record})
+	r.deadLetter.Flush()
+	if err := r.deadLetter.Error(); err != nil {
+		return fmt.Errorf("lenientTraceReader.record(): %w.", err)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package indexer_test
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/cosaques/algolia/indexer"
+)
+
+func TestLenientTraceReader(t *testing.T) {
+	tsv := "2015-08-01 00:04:00\tq1\n" +
+		"2015-08-01T00:04:01\tq2\n" +
+		"2015-08-01 00:04:02\tq3\n" +
+		"q4\n" +
+		"2015-08-01 00:04:04\tq5\n"
+
+	for _, tc := range []struct {
+		policy   indexer.ErrorPolicy
+		queries  []string
+		rejected int
+	}{
+		{indexer.FailOnError, []string{"q1"}, 0},
+		{indexer.SkipOnError, []string{"q1", "q3", "q5"}, 2},
+		{indexer.RecordOnError, []string{"q1", "q3", "q5"}, 2},
+	} {
+		var deadLetter strings.Builder
+		var rejected []int
+		traceReader := indexer.NewLenientTraceReader(indexer.NewTraceReader(strings.NewReader(tsv)), tc.policy, &deadLetter, func(traceErr *indexer.TraceError) {
+			rejected = append(rejected, traceErr.Line)
+		})
+
+		var queries []string
+		var err error
+		for {
+			var trace indexer.Trace
+			trace, err = traceReader.Read()
+			if err != nil {
+				break
+			}
+			queries = append(queries, trace.Query)
+		}
+
+		var traceErr *indexer.TraceError
+		if tc.policy == indexer.FailOnError {
+			if !errors.As(err, &traceErr) || traceErr.Line != 2 {
+				t.Errorf("Policy %d: error %v, want a TraceError at line 2", tc.policy, err)
+			}
+		} else if !errors.Is(err, io.EOF) {
+			t.Errorf("Policy %d: error %v, want EOF", tc.policy, err)
+		}
+		if strings.Join(queries, ",") != strings.Join(tc.queries, ",") {
+			t.Errorf("Policy %d: queries %v, want %v", tc.policy, queries, tc.queries)
+		}
+		if len(rejected) != tc.rejected {
+			t.Errorf("Policy %d: rejected lines %v, want %d of them", tc.policy, rejected, tc.rejected)
+		}
+
+		if tc.policy != indexer.RecordOnError {
+			if deadLetter.Len() != 0 {
+				t.Errorf("Policy %d: dead letter %q, want empty", tc.policy, deadLetter.String())
+			}
+			continue
+		}
+		// The dead letter is a tsv file of line numbers, reasons and contents.
+		tsvReader := csv.NewReader(strings.NewReader(deadLetter.String()))
+		tsvReader.Comma = '\t'
+		records, err := tsvReader.ReadAll()
+		if err != nil {
+			t.Fatalf("Error %v occured", err)
+		}
+		if len(records) != 2 ||
+			records[0][0] != "2" || records[0][1] == "" || records[0][2] != "2015-08-01T00:04:01\tq2" ||
+			records[1][0] != "4" || records[1][1] == "" || records[1][2] != "q4" {
+			t.Errorf("Dead letter %q, want lines 2 and 4 with their reason and content", deadLetter.String())
+		}
+	}
+}
+
+func TestParseErrorPolicy(t *testing.T) {
+	for value, want := range map[string]indexer.ErrorPolicy{"fail": indexer.FailOnError, "skip": indexer.SkipOnError, "record": indexer.RecordOnError} {
+		if policy, err := indexer.ParseErrorPolicy(value); err != nil || policy != want {
+			t.Errorf("ParseErrorPolicy(%q) = %v, %v, want %v", value, policy, err, want)
+		}
+	}
+	if _, err := indexer.ParseErrorPolicy("ignore"); err == nil {
+		t.Errorf("ParseErrorPolicy(%q) should fail", "ignore")
+	}
+}
@@ -0,0 +1,204 @@
+package indexer_test
+
+import (
+	"fmt"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/cosaques/algolia/indexer"
+)
+
+// quickTrace is a trace generated by testing/quick,
+// among 20 queries during 3 days.
+type quickTrace struct {
+	Minute uint16
+	Query  uint8
+}
+
+func (qt quickTrace) trace() indexer.Trace {
+	date := time.Date(2015, 8, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(qt.Minute%(3*24*60)) * time.Minute)
+	return indexer.Trace{date, fmt.Sprintf("Query %d", qt.Query%20)}
+}
+
+func quickTraces(qts []quickTrace) []indexer.Trace {
+	traces := make([]indexer.Trace, len(qts))
+	for i, qt := range qts {
+		traces[i] = qt.trace()
+	}
+	return traces
+}
+
+func TestAggregatorMergeProperty(t *testing.T) {
+	precisions := []indexer.TimePrecision{indexer.Year, indexer.Quarter, indexer.Month, indexer.Week, indexer.Day, indexer.Hour, indexer.Minute}
+	fanOut := []indexer.Option{indexer.WithPrecisions(precisions...)}
+	rollUp := []indexer.Option{indexer.WithPrecisions(precisions...), indexer.WithRollUp()}
+	tests := []struct {
+		name           string
+		merged, other  []indexer.Option
+		sealBeforehand bool
+	}{
+		{"FanOut", fanOut, fanOut, false},
+		{"RollUp", rollUp, rollUp, false},
+		{"RollUpSealed", rollUp, rollUp, true},
+		{"RollUpIntoFanOut", fanOut, rollUp, false},
+		{"FanOutIntoRollUpSealed", rollUp, fanOut, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Merging aggregators of two sets of traces is like ingesting their union.
+			property := func(a, b []quickTrace) bool {
+				merged, other, union := indexer.NewAggregator(tt.merged...), indexer.NewAggregator(tt.other...), indexer.NewAggregator(tt.merged...)
+				defer merged.Close()
+				defer other.Close()
+				defer union.Close()
+				merged.AddBatch(quickTraces(a))
+				other.AddBatch(quickTraces(b))
+				union.AddBatch(append(quickTraces(a), quickTraces(b)...))
+				if tt.sealBeforehand {
+					// The TimeRanges over before the latest trace of b are sealed.
+					merged.Add(indexer.Trace{time.Date(2015, 8, 4, 0, 0, 0, 0, time.UTC), "Query 0"})
+					union.Add(indexer.Trace{time.Date(2015, 8, 4, 0, 0, 0, 0, time.UTC), "Query 0"})
+					for _, qt := range b {
+						for _, precision := range precisions {
+							merged.GetIndex(indexer.TimeRange{qt.trace().Date, precision})
+						}
+					}
+				}
+				if err := merged.Merge(other); err != nil {
+					t.Errorf("Error %v occured", err)
+					return false
+				}
+
+				for _, qt := range append(a, b...) {
+					for _, precision := range precisions {
+						r := indexer.TimeRange{qt.trace().Date, precision}
+						want, _ := union.GetIndex(r)
+						got, _ := merged.GetIndex(r)
+						if got == nil || got.Len() != want.Len() || fmt.Sprint(topCounts(got)) != fmt.Sprint(topCounts(want)) ||
+							fmt.Sprint(rangeCounts(got)) != fmt.Sprint(rangeCounts(want)) {
+							t.Logf("GetIndex(%v) = %v, want %v", r, rangeCounts(got), rangeCounts(want))
+							return false
+						}
+					}
+				}
+				return true
+			}
+			if err := quick.Check(property, &quick.Config{MaxCount: 30}); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestMergeSubtractProperty(t *testing.T) {
+	tests := []struct {
+		name     string
+		newIndex func() indexer.Index
+	}{
+		{"Memory", indexer.NewMemoryIndex},
+		{"Disk", func() indexer.Index { return indexer.NewDiskIndex(t.TempDir(), 5) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Subtracting the queries merged into an index gives it back.
+			property := func(a, b []uint8) bool {
+				dst, src := tt.newIndex(), indexer.NewMemoryIndex()
+				for _, q := range a {
+					dst.Add(fmt.Sprintf("Query %d", q%20))
+				}
+				for _, q := range b {
+					src.Add(fmt.Sprintf("Query %d", q%20))
+				}
+				want := fmt.Sprint(rangeCounts(dst))
+
+				if err := indexer.Merge(dst, src); err != nil {
+					t.Errorf("Error %v occured", err)
+					return false
+				}
+				if err := indexer.Subtract(dst, src); err != nil {
+					t.Errorf("Error %v occured", err)
+					return false
+				}
+				return fmt.Sprint(rangeCounts(dst)) == want
+			}
+			if err := quick.Check(property, &quick.Config{MaxCount: 50}); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestSubtract(t *testing.T) {
+	topK, _ := indexer.NewAggregator(indexer.WithTopK(100)).GetIndex(indexer.TimeRange{time.Now(), indexer.Year})
+	if topK != nil {
+		t.Fatalf("GetIndex() = %v, want none", topK)
+	}
+	aggregator := indexer.NewAggregator(indexer.WithTopK(100))
+	aggregator.Add(indexer.Trace{time.Date(2015, 8, 1, 0, 0, 0, 0, time.UTC), "Query 0"})
+	topK, _ = aggregator.GetIndex(indexer.TimeRange{time.Date(2015, 8, 1, 0, 0, 0, 0, time.UTC), indexer.Year})
+
+	tests := []struct {
+		name string
+		dst  indexer.Index
+	}{
+		{"Memory", indexer.NewMemoryIndex()},
+		{"Disk", indexer.NewDiskIndex(t.TempDir(), 3)},
+		{"TopK", topK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Query i occurs 10*i times in dst and 10 times in src.
+			index(tt.dst, 5, 10)
+			src := indexer.NewMemoryIndex()
+			for i := 1; i <= 10; i++ {
+				for j := 0; j < 10; j++ {
+					src.Add(fmt.Sprintf("Query %d", i))
+				}
+			}
+
+			if err := indexer.Subtract(tt.dst, src); err != nil {
+				t.Fatalf("Error %v occured", err)
+			}
+			want := map[string]int{"Query 0": 1, "Query 2": 10, "Query 3": 20, "Query 4": 30, "Query 5": 40}
+			if tt.name != "TopK" {
+				delete(want, "Query 0")
+			}
+			if got := rangeCounts(tt.dst); fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("Counts = %v, want %v", got, want)
+			}
+		})
+	}
+
+	if err := indexer.Subtract(indexOnly{indexer.NewMemoryIndex()}, indexer.NewMemoryIndex()); err == nil {
+		t.Errorf("Subtract() should fail if the index isn't a Subtracter")
+	}
+}
+
+func TestAggregatorMergeVariants(t *testing.T) {
+	a := indexer.NewAggregator(indexer.WithNormalizers(indexer.CaseFold))
+	b := indexer.NewAggregator(indexer.WithNormalizers(indexer.CaseFold))
+	a.Add(indexer.Trace{time.Date(2015, 8, 1, 0, 0, 0, 0, time.UTC), "Golang"})
+	b.Add(indexer.Trace{time.Date(2015, 8, 1, 0, 0, 0, 0, time.UTC), "Golang"})
+	b.Add(indexer.Trace{time.Date(2015, 8, 1, 0, 0, 0, 0, time.UTC), "GOLANG"})
+
+	if err := a.Merge(b); err != nil {
+		t.Fatalf("Error %v occured", err)
+	}
+	want := []indexer.TopQuery{{Query: "Golang", Count: 2}, {Query: "GOLANG", Count: 1}}
+	if got := a.Variants("golang"); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Variants() = %v, want %v", got, want)
+	}
+	if err := a.Merge(a); err == nil {
+		t.Errorf("Merge() of an aggregator into itself should fail")
+	}
+}
+
+// topCounts returns the counts of the popular queries of an index.
+func topCounts(idx indexer.Index) []int {
+	var counts []int
+	for _, top := range idx.Top(5) {
+		counts = append(counts, top.Count)
+	}
+	return counts
+}
@@ -0,0 +1,86 @@
+package indexer
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Normalizer transforms a query before it is indexed,
+// so that its variants are indexed as a single query.
+type Normalizer func(string) string
+
+var (
+	// TrimSpace removes leading and trailing white spaces.
+	TrimSpace Normalizer = strings.TrimSpace
+	// CaseFold folds the case of letters, e.g. "GoLang" becomes "golang".
+	CaseFold Normalizer = func(s string) string {
+		// A Caser can't be used in concurrent way.
+		return cases.Fold().String(s)
+	}
+	// NFKC applies the Unicode compatibility normalization, e.g. "ﬁ" becomes "fi".
+	NFKC Normalizer = norm.NFKC.String
+	// CollapseSpace replaces sequences of white spaces by a single space.
+	CollapseSpace Normalizer = func(s string) string {
+		return strings.Join(strings.Fields(s), " ")
+	}
+	// URLDecode decodes URL-encoded queries, e.g. "go%20lang" becomes "go lang".
+	// Queries which aren't valid URL-encoded strings are kept.
+	URLDecode Normalizer = func(s string) string {
+		if decoded, err := url.QueryUnescape(s); err == nil {
+			return decoded
+		}
+		return s
+	}
+)
+
+// normalizers are the Normalizers known by ParseNormalizer.
+var normalizers = map[string]Normalizer{
+	"trim":  TrimSpace,
+	"fold":  CaseFold,
+	"nfkc":  NFKC,
+	"space": CollapseSpace,
+	"url":   URLDecode,
+}
+
+// ParseNormalizer returns a Normalizer by its name:
+// "trim", "fold", "nfkc", "space" or "url".
+func ParseNormalizer(name string) (Normalizer, error) {
+	if n, ok := normalizers[name]; ok {
+		return n, nil
+	}
+	return nil, fmt.Errorf("ParseNormalizer: Unknown normalizer %q.", name)
+}
+
+// RemoveStopWords returns a Normalizer removing given words from queries,
+// words being separated by white spaces which are collapsed.
+func RemoveStopWords(words ...string) Normalizer {
+	stopWords := make(map[string]bool, len(words))
+	for _, word := range words {
+		stopWords[word] = true
+	}
+
+	return func(s string) string {
+		words := strings.Fields(s)
+		kept := words[:0]
+		for _, word := range words {
+			if !stopWords[word] {
+				kept = append(kept, word)
+			}
+		}
+		return strings.Join(kept, " ")
+	}
+}
+
+// ChainNormalizers returns a Normalizer applying given ones in order.
+func ChainNormalizers(normalizers ...Normalizer) Normalizer {
+	return func(s string) string {
+		for _, n := range normalizers {
+			s = n(s)
+		}
+		return s
+	}
+}
@@ -0,0 +1,97 @@
+package indexer_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cosaques/algolia/indexer"
+)
+
+func TestNormalizers(t *testing.T) {
+	tests := []struct {
+		name       string
+		normalizer indexer.Normalizer
+		query      string
+		want       string
+	}{
+		{"TrimSpace", indexer.TrimSpace, " golang \t", "golang"},
+		{"CaseFold", indexer.CaseFold, "GoLang Straße", "golang strasse"},
+		{"NFKC", indexer.NFKC, "ﬁle ①", "file 1"},
+		{"CollapseSpace", indexer.CollapseSpace, " go \t lang  ", "go lang"},
+		{"URLDecode", indexer.URLDecode, "go%20lang+1", "go lang 1"},
+		{"URLDecodeInvalid", indexer.URLDecode, "100%", "100%"},
+		{"RemoveStopWords", indexer.RemoveStopWords("the", "a"), "the go  a lang", "go lang"},
+		{
+			"Chain",
+			indexer.ChainNormalizers(indexer.URLDecode, indexer.NFKC, indexer.CaseFold, indexer.CollapseSpace, indexer.RemoveStopWords("the")),
+			"The%20%20GoLang%20",
+			"golang",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.normalizer(tt.query); got != tt.want {
+				t.Errorf("Normalizer(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+
+	for _, name := range []string{"trim", "fold", "nfkc", "space", "url"} {
+		if _, err := indexer.ParseNormalizer(name); err != nil {
+			t.Errorf("ParseNormalizer(%q) error = %v", name, err)
+		}
+	}
+	if _, err := indexer.ParseNormalizer("stem"); err == nil {
+		t.Errorf("ParseNormalizer(%q) should fail", "stem")
+	}
+}
+
+func TestAggregatorWithNormalizers(t *testing.T) {
+	aggregator := indexer.NewAggregator(indexer.WithNormalizers(indexer.TrimSpace, indexer.CaseFold, indexer.RemoveStopWords("the")))
+	date := time.Date(2015, 8, 1, 0, 3, 43, 0, time.UTC)
+	aggregator.Add(indexer.Trace{date, "Golang"})
+	aggregator.Add(indexer.Trace{date, "golang "})
+	aggregator.AddBatch([]indexer.Trace{{date, "GoLang"}, {date, "golang "}, {date, "Rust"}, {date, "the"}})
+
+	r, _ := indexer.ParseTimeRange("2015-08-01")
+	idx, _ := aggregator.GetIndex(r)
+	if idx.Len() != 2 {
+		t.Errorf("GetIndex(%q).Len() = %d, want %d", r, idx.Len(), 2)
+	}
+	if top, want := idx.Top(1), (indexer.TopQuery{Query: "golang", Count: 4}); top[0] != want {
+		t.Errorf("GetIndex(%q).Top(1) = %v, want [%v]", r, top, want)
+	}
+
+	want := []indexer.TopQuery{{Query: "golang ", Count: 2}, {Query: "GoLang", Count: 1}, {Query: "Golang", Count: 1}}
+	got := aggregator.Variants("GOLANG")
+	if len(got) != len(want) {
+		t.Fatalf("Variants() = %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("Variants() = %v, want %v", got, want)
+			break
+		}
+	}
+
+	if variants := indexer.NewAggregator().Variants("golang"); variants != nil {
+		t.Errorf("Variants() without normalization = %v, want nil", variants)
+	}
+}
+
+func TestAggregatorWithMaxVariants(t *testing.T) {
+	aggregator := indexer.NewAggregator(indexer.WithNormalizers(indexer.CaseFold), indexer.WithMaxVariants(2))
+	date := time.Date(2015, 8, 1, 0, 3, 43, 0, time.UTC)
+	aggregator.AddBatch([]indexer.Trace{{date, "Golang"}, {date, "GOLANG"}, {date, "golang"}, {date, "Golang"}})
+
+	// The raw queries beyond the maximum aren't counted, the known ones still are.
+	want := []indexer.TopQuery{{Query: "Golang", Count: 2}, {Query: "GOLANG", Count: 1}}
+	if got := aggregator.Variants("golang"); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Variants() = %v, want %v", got, want)
+	}
+	r, _ := indexer.ParseTimeRange("2015-08-01")
+	if idx, _ := aggregator.GetIndex(r); indexer.Count(idx, "golang") != 4 {
+		t.Errorf("Count(%q) = %d, want %d", "golang", indexer.Count(idx, "golang"), 4)
+	}
+}
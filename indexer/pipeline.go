@@ -0,0 +1,144 @@
+package indexer
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// PipelineOptions describe how a Pipeline adds traces.
+type PipelineOptions struct {
+	// Workers is the number of goroutines adding batches to the aggregator.
+	Workers int
+	// BatchSize is the number of traces added at once.
+	BatchSize int
+	// FlushInterval is the periodicity of adding incomplete batches,
+	// so that slow streams of traces aren't delayed. They are only added
+	// when complete or flushed if it is 0.
+	FlushInterval time.Duration
+	// OnAdded, if not nil, is called with the number of traces of each added batch.
+	OnAdded func(n int)
+}
+
+// DefaultPipelineOptions are a worker per CPU, batches of 1000 traces flushed every second.
+var DefaultPipelineOptions = PipelineOptions{
+	Workers:       runtime.NumCPU(),
+	BatchSize:     1000,
+	FlushInterval: time.Second,
+}
+
+// Pipeline adds traces to an Aggregator by batches using a bounded pool of workers.
+// Add blocks while all the workers are busy and a batch is already waiting for them,
+// so that readers of traces don't get ahead of the indexation.
+type Pipeline struct {
+	aggregator Aggregator
+	options    PipelineOptions
+	// batch is the batch being filled.
+	batch []Trace
+	// mux allows to fill and flush the batch in concurrent way.
+	mux sync.Mutex
+	// batches are the batches waiting for a worker.
+	batches chan []Trace
+	// workers tracks the completion of workers.
+	workers sync.WaitGroup
+	// done stops the periodic flushes.
+	done chan struct{}
+}
+
+// NewPipeline creates a Pipeline and starts its workers.
+func NewPipeline(aggregator Aggregator, options PipelineOptions) *Pipeline {
+	if options.Workers < 1 {
+		options.Workers = 1
+	}
+	if options.BatchSize < 1 {
+		options.BatchSize = 1
+	}
+
+	p := &Pipeline{
+		aggregator: aggregator,
+		options:    options,
+		batch:      make([]Trace, 0, options.BatchSize),
+		// A single waiting batch is enough to keep the workers busy.
+		batches: make(chan []Trace, 1),
+		done:    make(chan struct{}),
+	}
+
+	for i := 0; i < options.Workers; i++ {
+		p.workers.Add(1)
+		go p.work()
+	}
+	if options.FlushInterval > 0 {
+		go p.flushPeriodically()
+	}
+
+	return p
+}
+
+// Add adds a Trace to the current batch, which is sent to the workers once complete.
+func (p *Pipeline) Add(t Trace) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	p.batch = append(p.batch, t)
+	if len(p.batch) >= p.options.BatchSize {
+		p.flush()
+	}
+}
+
+// Flush sends the current batch to the workers even if it is incomplete.
+func (p *Pipeline) Flush() {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	p.flush()
+}
+
+// Close flushes the current batch and waits until all the batches are added.
+// The Pipeline can't be used anymore.
+func (p *Pipeline) Close() {
+	close(p.done)
+
+	p.mux.Lock()
+	p.flush()
+	close(p.batches)
+	p.mux.Unlock()
+
+	p.workers.Wait()
+}
+
+// flush sends the current batch to the workers, waiting for one of them if needed.
+func (p *Pipeline) flush() {
+	if len(p.batch) == 0 {
+		return
+	}
+
+	p.batches <- p.batch
+	p.batch = make([]Trace, 0, p.options.BatchSize)
+}
+
+// work adds the batches to the aggregator.
+func (p *Pipeline) work() {
+	defer p.workers.Done()
+
+	for batch := range p.batches {
+		p.aggregator.AddBatch(batch)
+		if p.options.OnAdded != nil {
+			p.options.OnAdded(len(batch))
+		}
+	}
+}
+
+// flushPeriodically flushes the current batch with the FlushInterval.
+func (p *Pipeline) flushPeriodically() {
+	ticker := time.NewTicker(p.options.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.Flush()
+		case <-p.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package indexer_test
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cosaques/algolia/indexer"
+)
+
+func TestPipeline(t *testing.T) {
+	aggregator := indexer.NewAggregator()
+	var added int32
+	pipeline := indexer.NewPipeline(aggregator, indexer.PipelineOptions{
+		Workers:   3,
+		BatchSize: 7,
+		OnAdded: func(n int) {
+			atomic.AddInt32(&added, int32(n))
+		},
+	})
+
+	// Query i occurs i times.
+	for i := 1; i <= 20; i++ {
+		for j := 0; j < i; j++ {
+			pipeline.Add(indexer.Trace{time.Date(2015, 8, 1, 0, j, 0, 0, time.UTC), fmt.Sprintf("Query %d", i)})
+		}
+	}
+	pipeline.Close()
+
+	if want := 20 * 21 / 2; int(added) != want {
+		t.Errorf("Added %d traces, want %d", added, want)
+	}
+	r, _ := indexer.ParseTimeRange("2015-08-01")
+	idx, _ := aggregator.GetIndex(r)
+	if idx.Len() != 20 {
+		t.Errorf("GetIndex(%q).Len() = %d, want %d", r, idx.Len(), 20)
+	}
+	if top, want := idx.Top(1), (indexer.TopQuery{Query: "Query 20", Count: 20}); top[0] != want {
+		t.Errorf("GetIndex(%q).Top(1) = %v, want [%v]", r, top, want)
+	}
+}
+
+func TestPipelineFlushInterval(t *testing.T) {
+	aggregator := indexer.NewAggregator()
+	added := make(chan int, 1)
+	pipeline := indexer.NewPipeline(aggregator, indexer.PipelineOptions{
+		Workers:       1,
+		BatchSize:     1000,
+		FlushInterval: 10 * time.Millisecond,
+		OnAdded: func(n int) {
+			added <- n
+		},
+	})
+	defer pipeline.Close()
+
+	// An incomplete batch is added after the FlushInterval.
+	pipeline.Add(indexer.Trace{time.Date(2015, 8, 1, 0, 3, 43, 0, time.UTC), "q1"})
+	select {
+	case n := <-added:
+		if n != 1 {
+			t.Errorf("Added %d traces, want %d", n, 1)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("The incomplete batch isn't added")
+	}
+}
+
+func BenchmarkPipeline(b *testing.B) {
+	traces := readBenchTraces(b)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		pipeline := indexer.NewPipeline(indexer.NewAggregator(), indexer.DefaultPipelineOptions)
+		for _, trace := range traces {
+			pipeline.Add(trace)
+		}
+		pipeline.Close()
+	}
+}
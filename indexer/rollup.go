@@ -0,0 +1,175 @@
+package indexer
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// nestedPrecisions are the precisions whose TimeRanges divide the ones of a precision,
+// the coarsest first.
+var nestedPrecisions = map[TimePrecision][]TimePrecision{
+	Year:    {Quarter, Month, Day, Hour, Minute, Second},
+	Quarter: {Month, Day, Hour, Minute, Second},
+	Month:   {Day, Hour, Minute, Second},
+	Week:    {Day, Hour, Minute, Second},
+	Day:     {Hour, Minute, Second},
+	Hour:    {Minute, Second},
+	Minute:  {Second},
+}
+
+// WithRollUp makes the aggregator feed only the indexes of the finest maintained precisions
+// with traces, e.g. Minute indexes, the indexes of coarser precisions being derived from
+// the indexes of the next maintained precision dividing them (e.g. Hour ones from Minute ones).
+// A derived index is a view of its children till the watermark passes its end, then it's
+// sealed: its children are merged into a new index, which gets the late traces.
+func WithRollUp() Option {
+	return func(a *aggregator) {
+		a.rollUp = true
+	}
+}
+
+// childPrecision returns the coarsest maintained precision dividing a given one,
+// ok is false if there is none.
+func (a *aggregator) childPrecision(precision TimePrecision) (child TimePrecision, ok bool) {
+	for _, p := range nestedPrecisions[precision] {
+		if a.maintains(p) {
+			return p, true
+		}
+	}
+	return 0, false
+}
+
+// derived tells if indexes of a precision are derived from finer ones.
+func (a *aggregator) derived(precision TimePrecision) bool {
+	if !a.rollUp {
+		return false
+	}
+	_, ok := a.childPrecision(precision)
+	return ok
+}
+
+// sealed tells if a TimeRange ends before the watermark.
+func (a *aggregator) sealed(r TimeRange) bool {
+	return !r.End().After(time.Unix(0, atomic.LoadInt64(&a.watermark)))
+}
+
+// ingestIndex returns the index a trace of a TimeRange is added to, nil if there is none:
+// derived indexes only get the traces once sealed.
+func (a *aggregator) ingestIndex(r TimeRange) Index {
+	if a.derived(r.Precision) {
+		return a.lookupIndex(r)
+	}
+	return a.getOrCreateIndex(r)
+}
+
+// rollUpIndex returns a derived index composed of its children, it is nil if there are none.
+// The children of a sealed TimeRange are merged into a new index, which is kept.
+func (a *aggregator) rollUpIndex(r TimeRange) Index {
+	if !a.sealed(r) || a.expired(r) {
+		// Children could still get traces.
+		children := a.childIndexes(r, a.getIndex)
+		switch len(children) {
+		case 0:
+			return nil
+		case 1:
+			return children[0]
+		}
+		return newUnionIndex(children)
+	}
+
+	// Traces aren't ingested while the index is sealed, so that each of them
+	// is either merged from the children or added to the sealed index.
+	a.sealMux.Lock()
+	defer a.sealMux.Unlock()
+	return a.seal(r)
+}
+
+// seal returns the index of a sealed TimeRange, its children are merged into a new index
+// if it doesn't exist yet. sealMux has to be locked.
+func (a *aggregator) seal(r TimeRange) Index {
+	// The index could have been sealed meanwhile.
+	if idx := a.lookupIndex(r); idx != nil {
+		return idx
+	}
+
+	children := a.childIndexes(r, func(childRange TimeRange) Index {
+		// The children of a sealed TimeRange are sealed too.
+		if a.derived(childRange.Precision) && !a.expired(childRange) {
+			return a.seal(childRange)
+		}
+		return a.getIndex(childRange)
+	})
+	if len(children) == 0 {
+		return nil
+	}
+
+	// The children are merged at once.
+	src := children[0]
+	if len(children) > 1 {
+		src = newUnionIndex(children)
+	}
+	idx := a.createIndex(r)
+	if err := Merge(idx, src); err != nil {
+		log.Printf("aggregator.seal(): %v.", err)
+	}
+	if flusher, ok := idx.(Flusher); ok {
+		if err := flusher.Flush(); err != nil {
+			log.Printf("aggregator.seal(): %v.", err)
+		}
+	}
+
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	a.indexes[r.String()] = idx
+	return idx
+}
+
+// childIndexes returns the existing indexes of the child precision of a derived TimeRange
+// got with getIndex.
+func (a *aggregator) childIndexes(r TimeRange, getIndex func(TimeRange) Index) []Index {
+	child, _ := a.childPrecision(r.Precision)
+
+	// Children out of the indexed traces are skipped, so that the empty ones
+	// of a coarse TimeRange aren't rolled up.
+	earliest := time.Unix(0, atomic.LoadInt64(&a.earliest))
+	watermark := time.Unix(0, atomic.LoadInt64(&a.watermark))
+
+	var children []Index
+	for start, end := r.Start(), r.End(); start.Before(end); {
+		childRange := TimeRange{start, child}
+		if childRange.End().After(earliest) && !start.After(watermark) {
+			if idx := getIndex(childRange); idx != nil {
+				children = append(children, idx)
+			}
+		}
+		start = childRange.End()
+	}
+	return children
+}
+
+// sealParents seals the derived indexes whose children are about to be evicted.
+func (a *aggregator) sealParents() {
+	parents := make(map[TimeRange]bool)
+	a.mux.RLock()
+	for key := range a.indexes {
+		r, err := ParseTimeRange(key)
+		if err != nil || !a.expired(r) {
+			continue
+		}
+		for parent := range nestedPrecisions {
+			if child, ok := a.childPrecision(parent); ok && child == r.Precision && a.maintains(parent) {
+				parentRange := TimeRange{r.Date, parent}
+				parentRange.Date = parentRange.Start()
+				parents[parentRange] = true
+			}
+		}
+	}
+	a.mux.RUnlock()
+
+	for r := range parents {
+		if !a.expired(r) {
+			a.getIndex(r)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package indexer_test
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cosaques/algolia/indexer"
+)
+
+func TestAggregatorWithRollUp(t *testing.T) {
+	precisions := []indexer.TimePrecision{indexer.Month, indexer.Week, indexer.Day, indexer.Hour, indexer.Minute}
+
+	// Record the precisions of the created indexes.
+	var mux sync.Mutex
+	created := make(map[indexer.TimePrecision]int)
+	rolledUp := indexer.NewAggregator(indexer.WithPrecisions(precisions...), indexer.WithRollUp(), indexer.WithIndexFactory(func(r indexer.TimeRange) indexer.Index {
+		mux.Lock()
+		defer mux.Unlock()
+		created[r.Precision]++
+		return indexer.NewMemoryIndex()
+	}))
+	fanOut := indexer.NewAggregator(indexer.WithPrecisions(precisions...))
+
+	// Traces of 3 days in order.
+	random := rand.New(rand.NewSource(1))
+	var traces []indexer.Trace
+	start := time.Date(2015, 8, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 1000; i++ {
+		date := start.Add(time.Duration(i) * 3 * 24 * time.Hour / 1000)
+		traces = append(traces, indexer.Trace{date, fmt.Sprintf("Query %d", random.Intn(50))})
+	}
+	rolledUp.AddBatch(traces[:500])
+	rolledUp.AddBatch(traces[500:])
+	fanOut.AddBatch(traces)
+
+	// Only Minute indexes are fed.
+	if len(created) != 1 || created[indexer.Minute] == 0 {
+		t.Fatalf("Created indexes = %v, want only minutes", created)
+	}
+
+	for _, timeRange := range []string{"2015-08", "2015-W31", "2015-W32", "2015-08-01", "2015-08-03", "2015-08-01 13", "2015-08-03 23"} {
+		r, _ := indexer.ParseTimeRange(timeRange)
+		want, _ := fanOut.GetIndex(r)
+		got, err := rolledUp.GetIndex(r)
+		if err != nil {
+			t.Fatalf("Error %v occured", err)
+		}
+		if fmt.Sprint(rangeCounts(got)) != fmt.Sprint(rangeCounts(want)) {
+			t.Errorf("GetIndex(%q) = %v, want %v", timeRange, rangeCounts(got), rangeCounts(want))
+		}
+	}
+
+	// Sealed indexes are kept, open ones (2015-08, 2015-W32, 2015-08-03 and its last hour) are views of their children.
+	want := map[indexer.TimePrecision]int{indexer.Week: 1, indexer.Day: 2, indexer.Hour: 71, indexer.Minute: 1000}
+	if fmt.Sprint(created) != fmt.Sprint(want) {
+		t.Errorf("Created indexes = %v, want %v", created, want)
+	}
+
+	// Late traces are added to sealed indexes.
+	late := indexer.Trace{time.Date(2015, 8, 1, 13, 30, 0, 0, time.UTC), "Late query"}
+	rolledUp.Add(late)
+	for _, timeRange := range []string{"2015-08-01 13:30", "2015-08-01 13", "2015-08-01", "2015-W31", "2015-08"} {
+		r, _ := indexer.ParseTimeRange(timeRange)
+		if idx, _ := rolledUp.GetIndex(r); indexer.Count(idx, late.Query) != 1 {
+			t.Errorf("GetIndex(%q) should contain the late query", timeRange)
+		}
+	}
+}
+
+func TestAggregatorRollUpRetention(t *testing.T) {
+	aggregator := indexer.NewAggregator(
+		indexer.WithPrecisions(indexer.Day, indexer.Hour, indexer.Minute),
+		indexer.WithRollUp(),
+		indexer.WithRetention(indexer.Minute, 2*time.Hour),
+		indexer.WithEvictionInterval(0),
+	)
+	defer aggregator.Close()
+
+	aggregator.Add(indexer.Trace{time.Date(2015, 8, 1, 0, 3, 0, 0, time.UTC), "q1"})
+	aggregator.Add(indexer.Trace{time.Date(2015, 8, 1, 0, 4, 0, 0, time.UTC), "q2"})
+	aggregator.Add(indexer.Trace{time.Date(2015, 8, 1, 3, 0, 0, 0, time.UTC), "q3"})
+	if evicted := aggregator.Evict(); evicted != 2 {
+		t.Fatalf("Evict() = %d, want %d", evicted, 2)
+	}
+
+	// The hour of evicted minutes was sealed before.
+	for timeRange, want := range map[string]int{"2015-08-01 00:03": 0, "2015-08-01 00": 2, "2015-08-01": 3} {
+		r, _ := indexer.ParseTimeRange(timeRange)
+		idx, _ := aggregator.GetIndex(r)
+		got := 0
+		if idx != nil {
+			got = idx.Len()
+		}
+		if got != want {
+			t.Errorf("GetIndex(%q) has %d queries, want %d", timeRange, got, want)
+		}
+	}
+}
+
+func TestAggregatorRollUpLateTraces(t *testing.T) {
+	aggregator := indexer.NewAggregator(indexer.WithPrecisions(indexer.Hour, indexer.Minute), indexer.WithRollUp())
+	defer aggregator.Close()
+	// The hours are over, so they are sealed at their first request.
+	aggregator.Add(indexer.Trace{time.Date(2015, 8, 2, 0, 0, 0, 0, time.UTC), "q0"})
+
+	// Each hour is sealed while its late traces are added.
+	const n = 200
+	for h := 0; h < 24; h++ {
+		hour := indexer.TimeRange{time.Date(2015, 8, 1, h, 0, 0, 0, time.UTC), indexer.Hour}
+		started, done := make(chan struct{}), make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < n; i++ {
+				if i == n/2 {
+					close(started)
+				}
+				aggregator.Add(indexer.Trace{hour.Date.Add(time.Duration(i%60) * time.Minute), "q1"})
+			}
+		}()
+		<-started
+		aggregator.GetIndex(hour)
+		<-done
+
+		idx, _ := aggregator.GetIndex(hour)
+		if got := indexer.Count(idx, "q1"); got != n {
+			t.Fatalf("Count(%q) = %d in the sealed hour %v, want %d", "q1", got, hour, n)
+		}
+	}
+}
+
+// rangeCounts returns the counts of the queries of an index.
+func rangeCounts(idx indexer.Index) map[string]int {
+	counts := make(map[string]int)
+	if idx != nil {
+		idx.Range(func(query string, count int) bool {
+			counts[query] = count
+			return true
+		})
+	}
+	return counts
+}
@@ -0,0 +1,156 @@
+package indexer
+
+import (
+	"sort"
+	"strings"
+)
+
+// Searcher is implemented by indexes finding their queries faster than
+// by ranging over all of them.
+type Searcher interface {
+	// Search returns the queries starting with prefix and containing contains,
+	// the most popular first, at most size of them (all of them if size < 0).
+	Search(prefix, contains string, size int) []TopQuery
+}
+
+// Search returns the queries of an index starting with prefix and containing contains,
+// the most popular first, at most size of them (all of them if size < 0).
+// Indexes which aren't Searchers are ranged over.
+func Search(idx Index, prefix, contains string, size int) []TopQuery {
+	if searcher, ok := idx.(Searcher); ok {
+		return searcher.Search(prefix, contains, size)
+	}
+
+	var result []TopQuery
+	idx.Range(func(query string, count int) bool {
+		if matches(query, prefix, contains) {
+			result = append(result, TopQuery{Query: query, Count: count})
+		}
+		return true
+	})
+	return sortTopQueries(result, size)
+}
+
+// matches tells if a query starts with prefix and contains contains.
+func matches(query, prefix, contains string) bool {
+	return strings.HasPrefix(query, prefix) && strings.Contains(query, contains)
+}
+
+// sortTopQueries sorts queries by count, then alphabetically for the same count,
+// and keeps at most size of them (all of them if size < 0).
+func sortTopQueries(result []TopQuery, size int) []TopQuery {
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Query < result[j].Query
+	})
+
+	if size < 0 || size > len(result) {
+		size = len(result)
+	}
+	if result == nil {
+		return []TopQuery{}
+	}
+	return result[:size]
+}
+
+// trigramSize is the length in bytes of the n-grams of queryCatalog.
+const trigramSize = 3
+
+// queryCatalog finds queries by prefix, using queries sorted in lexicographic order,
+// or by substring, using the queries containing each trigram.
+type queryCatalog struct {
+	// sorted are the queries in lexicographic order.
+	sorted []*string
+	// pending are the queries added since sorted was last updated.
+	pending []*string
+	// trigrams are the queries containing each trigram, each query once.
+	trigrams map[string][]*string
+}
+
+// newQueryCatalog creates an instance of queryCatalog containing given queries.
+func newQueryCatalog(queries []*string) *queryCatalog {
+	c := &queryCatalog{
+		sorted:   make([]*string, 0, len(queries)),
+		trigrams: make(map[string][]*string),
+	}
+	for _, s := range queries {
+		c.add(s)
+	}
+	return c
+}
+
+// add adds a new query to the catalog.
+func (c *queryCatalog) add(s *string) {
+	// Sorting is delayed until the next search.
+	c.pending = append(c.pending, s)
+
+	seen := make(map[string]bool)
+	for i := 0; i+trigramSize <= len(*s); i++ {
+		trigram := (*s)[i : i+trigramSize]
+		if !seen[trigram] {
+			seen[trigram] = true
+			c.trigrams[trigram] = append(c.trigrams[trigram], s)
+		}
+	}
+}
+
+// find calls f for each query starting with prefix and containing contains.
+func (c *queryCatalog) find(prefix, contains string, f func(*string)) {
+	c.sortPending()
+
+	// Queries starting with prefix are contiguous in sorted.
+	candidates := c.sorted
+	if prefix != "" {
+		from := sort.Search(len(c.sorted), func(i int) bool {
+			return *c.sorted[i] >= prefix
+		})
+		to := from + sort.Search(len(c.sorted)-from, func(i int) bool {
+			return !strings.HasPrefix(*c.sorted[from+i], prefix)
+		})
+		candidates = c.sorted[from:to]
+	}
+
+	// Queries containing contains contain all its trigrams,
+	// the rarest one gives the fewest candidates.
+	for i := 0; i+trigramSize <= len(contains); i++ {
+		if queries := c.trigrams[contains[i:i+trigramSize]]; len(queries) < len(candidates) {
+			candidates = queries
+		}
+	}
+
+	for _, s := range candidates {
+		if matches(*s, prefix, contains) {
+			f(s)
+		}
+	}
+}
+
+// sortPending merges the pending queries into sorted ones.
+func (c *queryCatalog) sortPending() {
+	if len(c.pending) == 0 {
+		return
+	}
+
+	sort.Slice(c.pending, func(i, j int) bool {
+		return *c.pending[i] < *c.pending[j]
+	})
+
+	merged := make([]*string, 0, len(c.sorted)+len(c.pending))
+	i, j := 0, 0
+	for i < len(c.sorted) && j < len(c.pending) {
+		if *c.sorted[i] < *c.pending[j] {
+			merged = append(merged, c.sorted[i])
+			i++
+		} else {
+			merged = append(merged, c.pending[j])
+			j++
+		}
+	}
+	merged = append(merged, c.sorted[i:]...)
+	merged = append(merged, c.pending[j:]...)
+
+	c.sorted = merged
+	c.pending = nil
+}
@@ -0,0 +1,86 @@
+package indexer_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cosaques/algolia/indexer"
+)
+
+func TestSearch(t *testing.T) {
+	queries := map[string]int{
+		"http://rust-lang.org": 3,
+		"http://golang.org":    5,
+		"https://trust.com":    2,
+		"rust":                 4,
+		"ht":                   1,
+	}
+
+	memory := indexer.NewMemoryIndex()
+	topK := indexer.NewTopKIndex(10, 0.01)
+	for query, count := range queries {
+		for i := 0; i < count; i++ {
+			memory.Add(query)
+			topK.Add(query)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		prefix   string
+		contains string
+		size     int
+		want     []string
+	}{
+		{"Prefix", "http", "", -1, []string{"http://golang.org 5", "http://rust-lang.org 3", "https://trust.com 2"}},
+		{"ShortPrefix", "h", "", 2, []string{"http://golang.org 5", "http://rust-lang.org 3"}},
+		{"Contains", "", "rust", -1, []string{"rust 4", "http://rust-lang.org 3", "https://trust.com 2"}},
+		{"ShortContains", "", "t", 1, []string{"http://golang.org 5"}},
+		{"Both", "https", "rust", -1, []string{"https://trust.com 2"}},
+		{"All", "", "", 0, []string{}},
+		{"None", "ftp", "", -1, []string{}},
+	}
+	for _, idx := range []indexer.Index{memory, topK} {
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("%s %T", tt.name, idx), func(t *testing.T) {
+				got := indexer.Search(idx, tt.prefix, tt.contains, tt.size)
+				if len(got) != len(tt.want) {
+					t.Fatalf("Search(%q, %q, %d) = %v, want %v", tt.prefix, tt.contains, tt.size, got, tt.want)
+				}
+				for i := range got {
+					if s := fmt.Sprintf("%s %d", got[i].Query, got[i].Count); s != tt.want[i] {
+						t.Errorf("Search(%q, %q, %d) = %v, want %v", tt.prefix, tt.contains, tt.size, got, tt.want)
+						break
+					}
+				}
+			})
+		}
+	}
+
+	// Queries added after a search are found by the next ones.
+	memory.Add("rustacean")
+	memory.Add("http://rust-lang.org")
+	if got := indexer.Search(memory, "", "rust", -1); len(got) != 4 || got[0] != (indexer.TopQuery{Query: "http://rust-lang.org", Count: 4}) {
+		t.Errorf("Search(%q, %q, %d) after Add = %v", "", "rust", -1, got)
+	}
+	if got := indexer.Search(memory, "rusta", "", -1); len(got) != 1 || got[0].Query != "rustacean" {
+		t.Errorf("Search(%q, %q, %d) after Add = %v", "rusta", "", -1, got)
+	}
+}
+
+func TestAggregatorSearchRange(t *testing.T) {
+	aggregator := indexer.NewAggregator()
+	aggregator.Add(indexer.Trace{time.Date(2015, 8, 1, 23, 59, 0, 0, time.UTC), "golang"})
+	aggregator.Add(indexer.Trace{time.Date(2015, 8, 2, 0, 0, 0, 0, time.UTC), "golang"})
+	aggregator.Add(indexer.Trace{time.Date(2015, 8, 2, 0, 1, 0, 0, time.UTC), "go"})
+
+	idx, err := aggregator.GetRange(time.Date(2015, 8, 1, 12, 0, 0, 0, time.UTC), time.Date(2015, 8, 3, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Error %v occured", err)
+	}
+	got := indexer.Search(idx, "go", "lang", 10)
+	if want := (indexer.TopQuery{Query: "golang", Count: 2}); len(got) != 1 || got[0] != want {
+		t.Errorf("Search() = %v, want [%v]", got, want)
+	}
+}
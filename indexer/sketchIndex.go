@@ -0,0 +1,113 @@
+package indexer
+
+import (
+	"fmt"
+	"sync"
+)
+
+// sketchIndex estimates its count of distinct queries with a HyperLogLog sketch
+// instead of keeping them, its queries are read from finer indexes.
+type sketchIndex struct {
+	hll *hyperLogLog
+	// mux allows to read/write the sketch in concurrent way.
+	mux sync.RWMutex
+	// fine returns an index of the same time range composed of finer indexes,
+	// it is nil if there are none.
+	fine func() Index
+}
+
+// newSketchIndex creates an instance of sketchIndex.
+func newSketchIndex(errorRate float64, fine func() Index) *sketchIndex {
+	return &sketchIndex{
+		hll:  newHyperLogLog(errorRate),
+		fine: fine,
+	}
+}
+
+// Add adds new query to the index.
+func (idx *sketchIndex) Add(s string) {
+	idx.mux.Lock()
+	defer idx.mux.Unlock()
+
+	idx.hll.add(s)
+}
+
+// AddBatch adds new queries to the index at once.
+func (idx *sketchIndex) AddBatch(queries []string) {
+	idx.mux.Lock()
+	defer idx.mux.Unlock()
+
+	for _, s := range queries {
+		idx.hll.add(s)
+	}
+}
+
+// Merge adds the queries of src to the sketch.
+func (idx *sketchIndex) Merge(src Index) error {
+	if src == Index(idx) {
+		return fmt.Errorf("sketchIndex.Merge(): an index can't be merged into itself.")
+	}
+	srcQueries := copyQueries(src)
+
+	idx.mux.Lock()
+	defer idx.mux.Unlock()
+
+	for _, q := range srcQueries {
+		idx.hll.add(q.Query)
+	}
+	return nil
+}
+
+// Len gets the estimated count of distinct indexed queries.
+func (idx *sketchIndex) Len() int {
+	idx.mux.RLock()
+	defer idx.mux.RUnlock()
+
+	return idx.hll.count()
+}
+
+// ErrorRate returns the standard error of Len.
+func (idx *sketchIndex) ErrorRate() float64 {
+	return idx.hll.errorRate()
+}
+
+// Top returns most popular queries.
+func (idx *sketchIndex) Top(size int) []TopQuery {
+	if size < 0 {
+		size = 0
+	}
+	if fine := idx.fine(); fine != nil {
+		return fine.Top(size)
+	}
+	return []TopQuery{}
+}
+
+// Count returns the count of a query, 0 if it isn't indexed.
+func (idx *sketchIndex) Count(query string) int {
+	if fine := idx.fine(); fine != nil {
+		return Count(fine, query)
+	}
+	return 0
+}
+
+// Search returns the queries starting with prefix and containing contains,
+// the most popular first, at most size of them (all of them if size < 0).
+func (idx *sketchIndex) Search(prefix, contains string, size int) []TopQuery {
+	if fine := idx.fine(); fine != nil {
+		return Search(fine, prefix, contains, size)
+	}
+	return []TopQuery{}
+}
+
+// Range calls f for each indexed query and its count
+// while f returns true.
+func (idx *sketchIndex) Range(f func(query string, count int) bool) {
+	if fine := idx.fine(); fine != nil {
+		fine.Range(f)
+	}
+}
+
+// Close implements io.Closer interface, there is no indexation to stop.
+func (idx *sketchIndex) Close() error {
+	return nil
+}
@@ -0,0 +1,284 @@
+package indexer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Snapshot format:
+//
+//	magic "ALGIDX", version (uvarint), number of indexes (uvarint)
+//	for each index:
+//	    TimeRange key (string), number of queries (uvarint)
+//	    for each query: string id (uvarint), [query (string)], count (uvarint)
+//
+// Strings are prefixed by their length (uvarint). Queries are interned:
+// a query is written only the first time it appears and gets the next id,
+// later occurrences only refer to this id.
+const (
+	snapshotMagic   = "ALGIDX"
+	snapshotVersion = 1
+)
+
+// WriteTo writes a snapshot of all the indexes to w.
+// Indexes are captured one by one, so queries added meanwhile
+// may be present in some of them only. Approximate indexes aren't
+// written since they are rebuilt from Hour indexes.
+func (a *aggregator) WriteTo(w io.Writer) (int64, error) {
+	sw := &snapshotWriter{w: bufio.NewWriter(w), ids: make(map[string]uint64)}
+
+	// Capture the indexes to write.
+	a.mux.RLock()
+	keys := make([]string, 0, len(a.indexes))
+	indexes := make(map[string]Index, len(a.indexes))
+	for key, idx := range a.indexes {
+		if _, ok := idx.(Estimator); ok {
+			continue
+		}
+		keys = append(keys, key)
+		indexes[key] = idx
+	}
+	a.mux.RUnlock()
+	sort.Strings(keys)
+
+	sw.writeString(snapshotMagic)
+	sw.writeUvarint(snapshotVersion)
+	sw.writeUvarint(uint64(len(keys)))
+	for _, key := range keys {
+		// Collect the queries first since their number is written before them.
+		var queries []string
+		var counts []int
+		indexes[key].Range(func(query string, count int) bool {
+			queries = append(queries, query)
+			counts = append(counts, count)
+			return true
+		})
+
+		sw.writeString(key)
+		sw.writeUvarint(uint64(len(queries)))
+		for i, query := range queries {
+			sw.writeQuery(query)
+			sw.writeUvarint(uint64(counts[i]))
+		}
+	}
+
+	if sw.err == nil {
+		sw.err = sw.w.Flush()
+	}
+	if sw.err != nil {
+		return sw.n, fmt.Errorf("aggregator.WriteTo(): %w.", sw.err)
+	}
+	return sw.n, nil
+}
+
+// LoadAggregator creates an instance of aggregator from a snapshot
+// written by Aggregator.WriteTo. Indexes of the snapshot are kept in memory,
+// options apply to the indexes created afterwards.
+func LoadAggregator(r io.Reader, options ...Option) (Aggregator, error) {
+	sr := &snapshotReader{r: bufio.NewReader(r)}
+
+	if magic := sr.readString(); sr.err == nil && magic != snapshotMagic {
+		return nil, fmt.Errorf("LoadAggregator: not a snapshot (magic %q).", magic)
+	}
+	if version := sr.readUvarint(); sr.err == nil && version != snapshotVersion {
+		return nil, fmt.Errorf("LoadAggregator: unsupported snapshot version %d.", version)
+	}
+
+	a := NewAggregator(options...).(*aggregator)
+	// Read queries are referenced by the indexes they belong to.
+	sr.interner = a.interner
+	for i, n := uint64(0), sr.readUvarint(); i < n && sr.err == nil; i++ {
+		key := sr.readString()
+		// Queries are written in the order of their index, which is kept for equal counts.
+		var queries []*string
+		counts := make(map[*string]int)
+		for j, m := uint64(0), sr.readUvarint(); j < m && sr.err == nil; j++ {
+			s, count := sr.readQuery(), sr.readUvarint()
+			if sr.err != nil {
+				break
+			}
+			if _, exists := counts[s]; !exists {
+				queries = append(queries, s)
+			}
+			counts[s] += int(count)
+		}
+		if sr.err != nil {
+			break
+		}
+		r, err := ParseTimeRange(key)
+		if err == nil && !a.maintains(r.Precision) {
+			// The precision isn't maintained anymore.
+			continue
+		}
+		if err == nil {
+			// The latest trace is at least as recent as the start of its ranges.
+			a.updateWatermark(r.Start())
+		}
+		idx := newMemoryIndex(a.interner)
+		idx.setCounts(queries, counts)
+
+		// Run indexation in parallel.
+		go idx.run()
+
+		a.mux.Lock()
+		a.indexes[key] = idx
+		a.mux.Unlock()
+	}
+
+	sr.releaseQueries()
+
+	if sr.err != nil {
+		if errors.Is(sr.err, io.EOF) {
+			sr.err = io.ErrUnexpectedEOF
+		}
+		// Stop the goroutines of the aggregator and of the loaded indexes.
+		a.Close()
+		return nil, fmt.Errorf("LoadAggregator: %w.", sr.err)
+	}
+
+	a.rebuildApproximateIndexes()
+
+	return a, nil
+}
+
+// snapshotWriter writes snapshot's primitives
+// keeping the first occurred error.
+type snapshotWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+	// ids are the ids of already written queries.
+	ids map[string]uint64
+}
+
+func (sw *snapshotWriter) write(p []byte) {
+	if sw.err != nil {
+		return
+	}
+	n, err := sw.w.Write(p)
+	sw.n += int64(n)
+	sw.err = err
+}
+
+func (sw *snapshotWriter) writeUvarint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	sw.write(buf[:binary.PutUvarint(buf[:], v)])
+}
+
+func (sw *snapshotWriter) writeString(s string) {
+	sw.writeUvarint(uint64(len(s)))
+	sw.write([]byte(s))
+}
+
+func (sw *snapshotWriter) writeQuery(query string) {
+	if id, exists := sw.ids[query]; exists {
+		sw.writeUvarint(id)
+		return
+	}
+
+	// The query is written for the first time.
+	id := uint64(len(sw.ids))
+	sw.ids[query] = id
+	sw.writeUvarint(id)
+	sw.writeString(query)
+}
+
+// snapshotReader reads snapshot's primitives
+// keeping the first occurred error.
+type snapshotReader struct {
+	r   *bufio.Reader
+	err error
+	// queries are the already read queries ordered by their ids.
+	queries []*string
+	// interner caches the read queries.
+	interner *Interner
+}
+
+func (sr *snapshotReader) readUvarint() uint64 {
+	if sr.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(sr.r)
+	sr.err = err
+	return v
+}
+
+func (sr *snapshotReader) readString() string {
+	n := sr.readUvarint()
+	if sr.err != nil {
+		return ""
+	}
+	buf := make([]byte, n)
+	_, sr.err = io.ReadFull(sr.r, buf)
+	return string(buf)
+}
+
+func (sr *snapshotReader) readQuery() *string {
+	id := sr.readUvarint()
+	switch {
+	case sr.err != nil:
+		return nil
+	case id < uint64(len(sr.queries)):
+		return sr.queries[id]
+	case id == uint64(len(sr.queries)):
+		// The query occurs for the first time.
+		query := sr.readString()
+		if sr.err != nil {
+			return nil
+		}
+		s := sr.interner.Acquire(query)
+		sr.queries = append(sr.queries, s)
+		return s
+	default:
+		sr.err = fmt.Errorf("unknown query id %d", id)
+		return nil
+	}
+}
+
+// releaseQueries releases the references to the read queries.
+func (sr *snapshotReader) releaseQueries() {
+	for _, s := range sr.queries {
+		sr.interner.Release(s)
+	}
+}
+
+// rebuildApproximateIndexes feeds approximate indexes with the queries of Hour indexes.
+func (a *aggregator) rebuildApproximateIndexes() {
+	if !a.isApproximate(Year) {
+		return
+	}
+
+	a.mux.RLock()
+	hours := make(map[TimeRange]Index)
+	for key, idx := range a.indexes {
+		if r, err := ParseTimeRange(key); err == nil && r.Precision == Hour {
+			hours[r] = idx
+		}
+	}
+	a.mux.RUnlock()
+
+	for r, idx := range hours {
+		for _, precision := range []TimePrecision{Year, Quarter, Month, Week, Day} {
+			if !a.maintains(precision) {
+				continue
+			}
+			// Exact indexes of the snapshot already contain the queries.
+			switch coarse := a.getOrCreateIndex(TimeRange{r.Date, precision}).(type) {
+			case *sketchIndex:
+				idx.Range(func(query string, _ int) bool {
+					coarse.Add(query)
+					return true
+				})
+			case *topKIndex:
+				idx.Range(func(query string, count int) bool {
+					coarse.add(query, count, 0)
+					return true
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package indexer_test
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/cosaques/algolia/indexer"
+)
+
+func TestAggregatorSnapshot(t *testing.T) {
+	aggregator := indexer.NewAggregator()
+	traces := []indexer.Trace{
+		{time.Date(2015, 8, 1, 0, 3, 43, 0, time.UTC), "q1"},
+		{time.Date(2015, 8, 2, 0, 3, 43, 0, time.UTC), "q1"},
+		{time.Date(2015, 8, 2, 0, 3, 44, 0, time.UTC), "q2"},
+		{time.Date(2015, 8, 2, 0, 5, 45, 0, time.UTC), "q1"},
+	}
+	for _, trace := range traces {
+		aggregator.Add(trace)
+	}
+
+	var buf bytes.Buffer
+	if _, err := aggregator.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo() error %v occured", err)
+	}
+	snapshot := buf.Bytes()
+
+	loaded, err := indexer.LoadAggregator(bytes.NewReader(snapshot))
+	if err != nil {
+		t.Fatalf("LoadAggregator() error %v occured", err)
+	}
+
+	for _, timeRange := range []string{"2015", "2015-08-01", "2015-08-02", "2015-08-02 00:03", "2015-08-02 00:05"} {
+		r, _ := indexer.ParseTimeRange(timeRange)
+		want, _ := aggregator.GetIndex(r)
+		got, _ := loaded.GetIndex(r)
+		if got == nil {
+			t.Fatalf("GetIndex(%q) is nil after loading", timeRange)
+		}
+		if got.Len() != want.Len() {
+			t.Errorf("GetIndex(%q).Len() = %d, want %d", timeRange, got.Len(), want.Len())
+		}
+		if gotTop, wantTop := got.Top(1), want.Top(1); gotTop[0] != wantTop[0] {
+			t.Errorf("GetIndex(%q).Top(1) = %v, want %v", timeRange, gotTop, wantTop)
+		}
+	}
+
+	// The loaded aggregator keeps indexing.
+	loaded.Add(indexer.Trace{time.Date(2015, 8, 2, 0, 5, 45, 0, time.UTC), "q3"})
+	r, _ := indexer.ParseTimeRange("2015-08-02")
+	if idx, _ := loaded.GetIndex(r); idx.Len() != 3 {
+		t.Errorf("GetIndex(%q).Len() = %d after Add, want %d", "2015-08-02", idx.Len(), 3)
+	}
+
+	t.Run("Ties", func(t *testing.T) {
+		aggregator := indexer.NewAggregator(indexer.WithPrecisions(indexer.Day))
+		for i := 0; i < 50; i++ {
+			aggregator.Add(indexer.Trace{time.Date(2015, 8, 1, 0, 0, i, 0, time.UTC), fmt.Sprintf("q%d", i)})
+		}
+		var buf bytes.Buffer
+		if _, err := aggregator.WriteTo(&buf); err != nil {
+			t.Fatalf("WriteTo() error %v occured", err)
+		}
+		loaded, err := indexer.LoadAggregator(&buf, indexer.WithPrecisions(indexer.Day))
+		if err != nil {
+			t.Fatalf("LoadAggregator() error %v occured", err)
+		}
+
+		r, _ := indexer.ParseTimeRange("2015-08-01")
+		want, _ := aggregator.GetIndex(r)
+		got, _ := loaded.GetIndex(r)
+		if gotTop, wantTop := got.Top(10), want.Top(10); !reflect.DeepEqual(gotTop, wantTop) {
+			t.Errorf("GetIndex(%q).Top(10) = %v, want %v", r, gotTop, wantTop)
+		}
+	})
+
+	t.Run("NotSnapshot", func(t *testing.T) {
+		if _, err := indexer.LoadAggregator(bytes.NewReader([]byte("2015-08-01 00:03:43\tq1"))); err == nil {
+			t.Fatalf("LoadAggregator() error is nil, want error")
+		}
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		goroutines := runtime.NumGoroutine()
+		for i := 0; i < 10; i++ {
+			if _, err := indexer.LoadAggregator(bytes.NewReader(snapshot[:len(snapshot)-1]), indexer.WithRetention(indexer.Minute, time.Hour)); err == nil {
+				t.Fatalf("LoadAggregator() error is nil, want error")
+			}
+		}
+
+		// The goroutines of the aggregator and of its loaded indexes are stopped.
+		for i := 0; runtime.NumGoroutine() > goroutines && i < 100; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if n := runtime.NumGoroutine(); n > goroutines {
+			t.Errorf("%d goroutines are running after loading, want %d", n, goroutines)
+		}
+	})
+}
+
+func TestAggregatorSnapshotApproximateCount(t *testing.T) {
+	aggregator := indexer.NewAggregator(indexer.WithApproximateCount(0.01))
+	traces := []indexer.Trace{
+		{time.Date(2015, 8, 1, 0, 3, 43, 0, time.UTC), "q1"},
+		{time.Date(2015, 8, 1, 1, 3, 44, 0, time.UTC), "q2"},
+	}
+	for _, trace := range traces {
+		aggregator.Add(trace)
+	}
+
+	var buf bytes.Buffer
+	if _, err := aggregator.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo() error %v occured", err)
+	}
+	loaded, err := indexer.LoadAggregator(&buf, indexer.WithApproximateCount(0.01))
+	if err != nil {
+		t.Fatalf("LoadAggregator() error %v occured", err)
+	}
+
+	r, _ := indexer.ParseTimeRange("2015")
+	idx, _ := loaded.GetIndex(r)
+	if _, ok := idx.(indexer.Estimator); !ok {
+		t.Fatalf("GetIndex(%q) is %T, want an Estimator", r, idx)
+	}
+	if idx.Len() != 2 {
+		t.Errorf("GetIndex(%q).Len() = %d, want %d", r, idx.Len(), 2)
+	}
+}
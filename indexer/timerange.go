@@ -5,7 +5,8 @@ import (
 	"time"
 )
 
-// TimePrecision represent different levels of date's precision (Year, Month, Day, Hour, Minute).
+// TimePrecision represent different levels of date's precision
+// (Year, Quarter, Month, Week, Day, Hour, Minute, Second).
 type TimePrecision int
 
 const (
@@ -14,13 +15,61 @@ const (
 	Day
 	Hour
 	Minute
+	Second
+	// Week is an ISO 8601 week, starting on Monday.
+	Week
+	Quarter
 	year_layout   = "2006"
 	month_layout  = "2006-01"
 	day_layout    = "2006-01-02"
 	hour_layout   = "2006-01-02 15"
 	minute_layout = "2006-01-02 15:04"
+	second_layout = "2006-01-02 15:04:05"
+	// week_format and quarter_format can't be expressed as layouts of time.Format.
+	week_format    = "%04d-W%02d"
+	quarter_format = "%04d-Q%d"
 )
 
+// precisionNames are the names of precisions, e.g. used in flags.
+var precisionNames = map[TimePrecision]string{
+	Year:    "year",
+	Quarter: "quarter",
+	Month:   "month",
+	Week:    "week",
+	Day:     "day",
+	Hour:    "hour",
+	Minute:  "minute",
+	Second:  "second",
+}
+
+// String returns the name of a precision.
+func (p TimePrecision) String() string {
+	if name, ok := precisionNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("TimePrecision(%d)", int(p))
+}
+
+// ParseTimePrecision parses a precision from its name, e.g. "day".
+func ParseTimePrecision(value string) (TimePrecision, error) {
+	for precision, name := range precisionNames {
+		if name == value {
+			return precision, nil
+		}
+	}
+	return 0, fmt.Errorf("ParseTimePrecision: Unknown precision %q.", value)
+}
+
+// coarserThanHour tells if TimeRanges of a precision contain whole hours.
+func (p TimePrecision) coarserThanHour() bool {
+	switch p {
+	case Year, Quarter, Month, Week, Day:
+		return true
+	default:
+		return false
+	}
+}
+
 // TimeRange represents a time range that could be
 // presented by a date and its precision (TimePrecision).
 type TimeRange struct {
@@ -39,13 +88,128 @@ func (r TimeRange) String() string {
 		return r.Date.Format(day_layout)
 	case Hour:
 		return r.Date.Format(hour_layout)
+	case Second:
+		return r.Date.Format(second_layout)
+	case Week:
+		year, week := r.Date.ISOWeek()
+		return fmt.Sprintf(week_format, year, week)
+	case Quarter:
+		return fmt.Sprintf(quarter_format, r.Date.Year(), (r.Date.Month()-1)/3+1)
 	default:
 		return r.Date.Format(minute_layout)
 	}
 }
 
+// Start returns the first instant of the TimeRange.
+func (r TimeRange) Start() time.Time {
+	y, m, d := r.Date.Date()
+	loc := r.Date.Location()
+	switch r.Precision {
+	case Year:
+		return time.Date(y, 1, 1, 0, 0, 0, 0, loc)
+	case Month:
+		return time.Date(y, m, 1, 0, 0, 0, 0, loc)
+	case Day:
+		return time.Date(y, m, d, 0, 0, 0, 0, loc)
+	case Hour:
+		return time.Date(y, m, d, r.Date.Hour(), 0, 0, 0, loc)
+	case Second:
+		return time.Date(y, m, d, r.Date.Hour(), r.Date.Minute(), r.Date.Second(), 0, loc)
+	case Week:
+		// Go back to Monday.
+		return time.Date(y, m, d-(int(r.Date.Weekday())+6)%7, 0, 0, 0, 0, loc)
+	case Quarter:
+		return time.Date(y, (m-1)/3*3+1, 1, 0, 0, 0, 0, loc)
+	default:
+		return time.Date(y, m, d, r.Date.Hour(), r.Date.Minute(), 0, 0, loc)
+	}
+}
+
+// End returns the first instant following the TimeRange.
+func (r TimeRange) End() time.Time {
+	start := r.Start()
+	switch r.Precision {
+	case Year:
+		return start.AddDate(1, 0, 0)
+	case Month:
+		return start.AddDate(0, 1, 0)
+	case Day:
+		return start.AddDate(0, 0, 1)
+	case Hour:
+		return start.Add(time.Hour)
+	case Second:
+		return start.Add(time.Second)
+	case Week:
+		return start.AddDate(0, 0, 7)
+	case Quarter:
+		return start.AddDate(0, 3, 0)
+	default:
+		return start.Add(time.Minute)
+	}
+}
+
+// Prev returns the TimeRange of the same precision preceding the TimeRange.
+func (r TimeRange) Prev() TimeRange {
+	prev := TimeRange{r.Start().Add(-time.Nanosecond), r.Precision}
+	prev.Date = prev.Start()
+	return prev
+}
+
+// CoverTimeRanges returns the minimal set of TimeRanges covering [from, to).
+// Both bounds are truncated to the Second precision, the finest one indexed.
+// Weeks aren't used since they aren't nested in months.
+func CoverTimeRanges(from, to time.Time) []TimeRange {
+	// Second always fits since both bounds are aligned to it.
+	result, _ := coverTimeRanges(from, to, func(TimePrecision) bool { return true })
+	return result
+}
+
+// coverTimeRanges returns the minimal set of TimeRanges covering [from, to)
+// using only the precisions accepted by use, ok is false if they can't cover it.
+func coverTimeRanges(from, to time.Time, use func(TimePrecision) bool) (result []TimeRange, ok bool) {
+	// Precisions from the coarsest to the finest, each one nested in the previous.
+	precisions := []TimePrecision{Year, Quarter, Month, Day, Hour, Minute, Second}
+
+	from = TimeRange{from, Second}.Start()
+	to = TimeRange{to, Second}.Start()
+
+	for from.Before(to) {
+		// Take the coarsest range starting at "from" and not exceeding "to".
+		covered := false
+		for _, precision := range precisions {
+			r := TimeRange{from, precision}
+			if use(precision) && r.Start().Equal(from) && !r.End().After(to) {
+				result = append(result, r)
+				from = r.End()
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			return nil, false
+		}
+	}
+
+	return result, true
+}
+
 // ParseTimeRange parses a string to a TimeRange
 func ParseTimeRange(value string) (TimeRange, error) {
+	return ParseTimeRangeIn(value, time.UTC)
+}
+
+// ParseTimeRangeIn parses a string to a TimeRange of a given time zone,
+// e.g. "2015-08-02" in Europe/Paris starts at 2015-08-01 22:00 UTC.
+func ParseTimeRangeIn(value string, loc *time.Location) (TimeRange, error) {
+	// Weeks and quarters are told by their letter,
+	// a quarter having the size of a month.
+	switch {
+	case len(value) == len("2006-W01") && value[4:6] == "-W":
+		return parseWeek(value, loc)
+	case len(value) == len("2006-Q1") && value[4:6] == "-Q":
+		return parseQuarter(value, loc)
+	}
+
 	patterns := []struct {
 		layout    string
 		precision TimePrecision
@@ -55,12 +219,13 @@ func ParseTimeRange(value string) (TimeRange, error) {
 		{day_layout, Day},
 		{hour_layout, Hour},
 		{minute_layout, Minute},
+		{second_layout, Second},
 	}
 
 	for _, pattern := range patterns {
 		// Each precision has its unique size.
 		if len(value) == len(pattern.layout) {
-			if date, err := time.Parse(pattern.layout, value); err != nil {
+			if date, err := time.ParseInLocation(pattern.layout, value, loc); err != nil {
 				return TimeRange{}, fmt.Errorf("ParseTimeRange: %w.", err)
 			} else {
 				return TimeRange{date, pattern.precision}, nil
@@ -70,3 +235,32 @@ func ParseTimeRange(value string) (TimeRange, error) {
 
 	return TimeRange{}, fmt.Errorf("ParseTimeRange: Uknown timerange format %q.", value)
 }
+
+// parseWeek parses a string like "2015-W31" to a Week TimeRange.
+func parseWeek(value string, loc *time.Location) (TimeRange, error) {
+	var year, week int
+	if _, err := fmt.Sscanf(value, week_format, &year, &week); err != nil {
+		return TimeRange{}, fmt.Errorf("ParseTimeRange: %w.", err)
+	}
+
+	// The 4th of January is always in the first week.
+	jan4 := time.Date(year, 1, 4, 0, 0, 0, 0, loc)
+	r := TimeRange{jan4.AddDate(0, 0, (week-1)*7), Week}
+	if y, w := r.Date.ISOWeek(); y != year || w != week {
+		return TimeRange{}, fmt.Errorf("ParseTimeRange: Week %d out of range in %q.", week, value)
+	}
+	return TimeRange{r.Start(), Week}, nil
+}
+
+// parseQuarter parses a string like "2015-Q3" to a Quarter TimeRange.
+func parseQuarter(value string, loc *time.Location) (TimeRange, error) {
+	var year, quarter int
+	if _, err := fmt.Sscanf(value, quarter_format, &year, &quarter); err != nil {
+		return TimeRange{}, fmt.Errorf("ParseTimeRange: %w.", err)
+	}
+	if quarter < 1 || quarter > 4 {
+		return TimeRange{}, fmt.Errorf("ParseTimeRange: Quarter %d out of range in %q.", quarter, value)
+	}
+
+	return TimeRange{time.Date(year, time.Month(quarter-1)*3+1, 1, 0, 0, 0, 0, loc), Quarter}, nil
+}
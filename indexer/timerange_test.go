@@ -47,6 +47,42 @@ func TestParseTimeRange(t *testing.T) {
 			indexer.TimeRange{time.Date(2015, 8, 1, 0, 4, 0, 0, time.UTC), indexer.Minute},
 			false,
 		},
+		{
+			"Second",
+			args{"2015-08-01 00:04:30"},
+			indexer.TimeRange{time.Date(2015, 8, 1, 0, 4, 30, 0, time.UTC), indexer.Second},
+			false,
+		},
+		{
+			"Week",
+			args{"2015-W31"},
+			indexer.TimeRange{time.Date(2015, 7, 27, 0, 0, 0, 0, time.UTC), indexer.Week},
+			false,
+		},
+		{
+			"WeekOfPreviousYear",
+			args{"2015-W53"},
+			indexer.TimeRange{time.Date(2015, 12, 28, 0, 0, 0, 0, time.UTC), indexer.Week},
+			false,
+		},
+		{
+			"WeekOutOfRange",
+			args{"2014-W53"},
+			indexer.TimeRange{},
+			true,
+		},
+		{
+			"Quarter",
+			args{"2015-Q3"},
+			indexer.TimeRange{time.Date(2015, 7, 1, 0, 0, 0, 0, time.UTC), indexer.Quarter},
+			false,
+		},
+		{
+			"QuarterOutOfRange",
+			args{"2015-Q5"},
+			indexer.TimeRange{},
+			true,
+		},
 		{
 			"ErrorFormat",
 			args{"2015-08-01T00:04"},
@@ -55,7 +91,7 @@ func TestParseTimeRange(t *testing.T) {
 		},
 		{
 			"NotSupportedFormat",
-			args{"2015-08-01 00:04:30"},
+			args{"2015-08-01 00:04:30.000"},
 			indexer.TimeRange{},
 			true,
 		},
@@ -105,6 +141,26 @@ func TestTimeRange_String(t *testing.T) {
 			indexer.TimeRange{time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC), indexer.Minute},
 			"2006-01-02 15:04",
 		},
+		{
+			"Second",
+			indexer.TimeRange{time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC), indexer.Second},
+			"2006-01-02 15:04:05",
+		},
+		{
+			"Week",
+			indexer.TimeRange{time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC), indexer.Week},
+			"2006-W01",
+		},
+		{
+			"WeekOfPreviousYear",
+			indexer.TimeRange{time.Date(2006, 1, 1, 15, 4, 5, 0, time.UTC), indexer.Week},
+			"2005-W52",
+		},
+		{
+			"Quarter",
+			indexer.TimeRange{time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC), indexer.Quarter},
+			"2006-Q1",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -114,3 +170,169 @@ func TestTimeRange_String(t *testing.T) {
 		})
 	}
 }
+
+func TestTimeRange_StartEnd(t *testing.T) {
+	date := time.Date(2016, 2, 29, 15, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name      string
+		precision indexer.TimePrecision
+		start     time.Time
+		end       time.Time
+	}{
+		{
+			"Year",
+			indexer.Year,
+			time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC),
+			time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			"Month",
+			indexer.Month,
+			time.Date(2016, 2, 1, 0, 0, 0, 0, time.UTC),
+			time.Date(2016, 3, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			"Day",
+			indexer.Day,
+			time.Date(2016, 2, 29, 0, 0, 0, 0, time.UTC),
+			time.Date(2016, 3, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			"Hour",
+			indexer.Hour,
+			time.Date(2016, 2, 29, 15, 0, 0, 0, time.UTC),
+			time.Date(2016, 2, 29, 16, 0, 0, 0, time.UTC),
+		},
+		{
+			"Minute",
+			indexer.Minute,
+			time.Date(2016, 2, 29, 15, 4, 0, 0, time.UTC),
+			time.Date(2016, 2, 29, 15, 5, 0, 0, time.UTC),
+		},
+		{
+			"Second",
+			indexer.Second,
+			time.Date(2016, 2, 29, 15, 4, 5, 0, time.UTC),
+			time.Date(2016, 2, 29, 15, 4, 6, 0, time.UTC),
+		},
+		{
+			"Week",
+			indexer.Week,
+			time.Date(2016, 2, 29, 0, 0, 0, 0, time.UTC),
+			time.Date(2016, 3, 7, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			"Quarter",
+			indexer.Quarter,
+			time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC),
+			time.Date(2016, 4, 1, 0, 0, 0, 0, time.UTC),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := indexer.TimeRange{date, tt.precision}
+			if got := r.Start(); !got.Equal(tt.start) {
+				t.Errorf("TimeRange.Start() = %v, want %v", got, tt.start)
+			}
+			if got := r.End(); !got.Equal(tt.end) {
+				t.Errorf("TimeRange.End() = %v, want %v", got, tt.end)
+			}
+		})
+	}
+}
+
+func TestTimeRange_Prev(t *testing.T) {
+	for value, want := range map[string]string{
+		"2016":                "2015",
+		"2016-Q1":             "2015-Q4",
+		"2016-03":             "2016-02",
+		"2016-W01":            "2015-W53",
+		"2016-03-01":          "2016-02-29",
+		"2016-03-01 00":       "2016-02-29 23",
+		"2016-03-01 00:00":    "2016-02-29 23:59",
+		"2016-03-01 00:00:00": "2016-02-29 23:59:59",
+	} {
+		r, err := indexer.ParseTimeRange(value)
+		if err != nil {
+			t.Fatalf("Error %v occured", err)
+		}
+		if got := r.Prev(); got.String() != want || !got.End().Equal(r.Start()) {
+			t.Errorf("TimeRange(%s).Prev() = %v, want %s", value, got, want)
+		}
+	}
+}
+
+func TestCoverTimeRanges(t *testing.T) {
+	tests := []struct {
+		name string
+		from string
+		to   string
+		want []string
+	}{
+		{
+			"Empty",
+			"2015-08-01 13:20",
+			"2015-08-01 13:20",
+			nil,
+		},
+		{
+			"Aligned",
+			"2015-08-01",
+			"2015-08-02",
+			[]string{"2015-08-01"},
+		},
+		{
+			"Unaligned",
+			"2015-08-01 13:20",
+			"2015-08-03 02:10",
+			[]string{
+				"2015-08-01 13:20", "2015-08-01 13:21", "2015-08-01 13:22", "2015-08-01 13:23", "2015-08-01 13:24",
+				"2015-08-01 13:25", "2015-08-01 13:26", "2015-08-01 13:27", "2015-08-01 13:28", "2015-08-01 13:29",
+				"2015-08-01 13:30", "2015-08-01 13:31", "2015-08-01 13:32", "2015-08-01 13:33", "2015-08-01 13:34",
+				"2015-08-01 13:35", "2015-08-01 13:36", "2015-08-01 13:37", "2015-08-01 13:38", "2015-08-01 13:39",
+				"2015-08-01 13:40", "2015-08-01 13:41", "2015-08-01 13:42", "2015-08-01 13:43", "2015-08-01 13:44",
+				"2015-08-01 13:45", "2015-08-01 13:46", "2015-08-01 13:47", "2015-08-01 13:48", "2015-08-01 13:49",
+				"2015-08-01 13:50", "2015-08-01 13:51", "2015-08-01 13:52", "2015-08-01 13:53", "2015-08-01 13:54",
+				"2015-08-01 13:55", "2015-08-01 13:56", "2015-08-01 13:57", "2015-08-01 13:58", "2015-08-01 13:59",
+				"2015-08-01 14", "2015-08-01 15", "2015-08-01 16", "2015-08-01 17", "2015-08-01 18",
+				"2015-08-01 19", "2015-08-01 20", "2015-08-01 21", "2015-08-01 22", "2015-08-01 23",
+				"2015-08-02",
+				"2015-08-03 00", "2015-08-03 01",
+				"2015-08-03 02:00", "2015-08-03 02:01", "2015-08-03 02:02", "2015-08-03 02:03", "2015-08-03 02:04",
+				"2015-08-03 02:05", "2015-08-03 02:06", "2015-08-03 02:07", "2015-08-03 02:08", "2015-08-03 02:09",
+			},
+		},
+		{
+			"Years",
+			"2014-12",
+			"2017-02",
+			[]string{"2014-12", "2015", "2016", "2017-01"},
+		},
+		{
+			"QuartersAndSeconds",
+			"2015-06-30 23:59:58",
+			"2016-02",
+			[]string{"2015-06-30 23:59:58", "2015-06-30 23:59:59", "2015-Q3", "2015-Q4", "2016-01"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, _ := indexer.ParseTimeRange(tt.from)
+			to, _ := indexer.ParseTimeRange(tt.to)
+
+			var got []string
+			for _, r := range indexer.CoverTimeRanges(from.Start(), to.Start()) {
+				got = append(got, r.String())
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("CoverTimeRanges() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("CoverTimeRanges() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package indexer
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+type (
+	// topKIndex keeps only a bounded number of the most popular queries
+	// using the Space-Saving algorithm, its count of distinct queries
+	// is estimated with a HyperLogLog sketch.
+	topKIndex struct {
+		hll *hyperLogLog
+		// counters is a map of monitored queries and their counters.
+		counters map[string]*topKCounter
+		// minHeap orders the counters by their counts, the least popular first.
+		minHeap topKHeap
+		// capacity is the maximum number of monitored queries.
+		capacity int
+		// mux allows to read/write maps and slices in concurrent way.
+		mux sync.RWMutex
+	}
+
+	// topKCounter counts a monitored query.
+	topKCounter struct {
+		query string
+		count int
+		// err is the maximum overestimation of count, i.e. the count
+		// of the evicted query this counter has been taken from.
+		err int
+		// heapIndex is the position of the counter in minHeap.
+		heapIndex int
+	}
+)
+
+// NewTopKIndex creates an instance of topKIndex monitoring at most capacity queries.
+// Counts of the returned popular queries are overestimated by at most TopQuery.Error,
+// the count of distinct queries is estimated with a given standard error.
+func NewTopKIndex(capacity int, errorRate float64) Index {
+	return newTopKIndex(capacity, errorRate)
+}
+
+// newTopKIndex creates an instance of topKIndex.
+func newTopKIndex(capacity int, errorRate float64) *topKIndex {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &topKIndex{
+		hll:      newHyperLogLog(errorRate),
+		counters: make(map[string]*topKCounter),
+		capacity: capacity,
+	}
+}
+
+// Add adds new query to the index.
+func (idx *topKIndex) Add(s string) {
+	idx.add(s, 1, 0)
+}
+
+// AddBatch adds new queries to the index, the occurrences of a query
+// being added at once in the order of their first appearance.
+func (idx *topKIndex) AddBatch(queries []string) {
+	counts := make(map[string]int)
+	var order []string
+	for _, s := range queries {
+		if counts[s] == 0 {
+			order = append(order, s)
+		}
+		counts[s]++
+	}
+
+	for _, s := range order {
+		idx.add(s, counts[s], 0)
+	}
+}
+
+// Merge adds the queries of src with their counts to the index,
+// the errors of the queries of a topKIndex are added too.
+func (idx *topKIndex) Merge(src Index) error {
+	if src == Index(idx) {
+		return fmt.Errorf("topKIndex.Merge(): an index can't be merged into itself.")
+	}
+
+	for _, q := range copyQueries(src) {
+		idx.add(q.Query, q.Count, q.Error)
+	}
+	return nil
+}
+
+// Subtract subtracts the counts of the queries of src from the ones of the monitored queries,
+// queries whose count isn't positive anymore are removed. The count of distinct queries
+// isn't decreased.
+func (idx *topKIndex) Subtract(src Index) error {
+	if src == Index(idx) {
+		return fmt.Errorf("topKIndex.Subtract(): an index can't be subtracted from itself.")
+	}
+	srcQueries := copyQueries(src)
+
+	idx.mux.Lock()
+	defer idx.mux.Unlock()
+
+	for _, q := range srcQueries {
+		c, exists := idx.counters[q.Query]
+		if !exists {
+			continue
+		}
+
+		c.count -= q.Count
+		if c.count <= 0 {
+			heap.Remove(&idx.minHeap, c.heapIndex)
+			delete(idx.counters, q.Query)
+			continue
+		}
+		if c.err > c.count {
+			c.err = c.count
+		}
+		heap.Fix(&idx.minHeap, c.heapIndex)
+	}
+	return nil
+}
+
+// add adds n occurrences of a query overestimated by err to the index.
+func (idx *topKIndex) add(s string, n, err int) {
+	idx.mux.Lock()
+	defer idx.mux.Unlock()
+
+	idx.hll.add(s)
+
+	if c, exists := idx.counters[s]; exists {
+		c.count += n
+		c.err += err
+		heap.Fix(&idx.minHeap, c.heapIndex)
+		return
+	}
+
+	if len(idx.counters) < idx.capacity {
+		c := &topKCounter{query: s, count: n, err: err}
+		idx.counters[s] = c
+		heap.Push(&idx.minHeap, c)
+		return
+	}
+
+	// Replace the least popular query, the new one
+	// could have been counted by its counter.
+	c := idx.minHeap[0]
+	delete(idx.counters, c.query)
+	c.query, c.err, c.count = s, c.count+err, c.count+n
+	idx.counters[s] = c
+	heap.Fix(&idx.minHeap, c.heapIndex)
+}
+
+// Len gets the estimated count of distinct indexed queries.
+func (idx *topKIndex) Len() int {
+	idx.mux.RLock()
+	defer idx.mux.RUnlock()
+
+	return idx.hll.count()
+}
+
+// ErrorRate returns the standard error of Len.
+func (idx *topKIndex) ErrorRate() float64 {
+	return idx.hll.errorRate()
+}
+
+// Top returns most popular queries.
+func (idx *topKIndex) Top(size int) []TopQuery {
+	idx.mux.RLock()
+	result := make([]TopQuery, 0, len(idx.counters))
+	for _, c := range idx.counters {
+		result = append(result, TopQuery{Query: c.query, Count: c.count, Error: c.err})
+	}
+	idx.mux.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Count > result[j].Count
+	})
+
+	// Check that asked size is less than current count of monitored queries.
+	if size < 0 {
+		size = 0
+	} else if size > len(result) {
+		size = len(result)
+	}
+
+	return result[:size]
+}
+
+// Count returns the count of a monitored query, 0 if it isn't monitored.
+func (idx *topKIndex) Count(query string) int {
+	idx.mux.RLock()
+	defer idx.mux.RUnlock()
+
+	if c, exists := idx.counters[query]; exists {
+		return c.count
+	}
+	return 0
+}
+
+// queries returns the monitored queries with their counts and errors.
+func (idx *topKIndex) queries() []TopQuery {
+	idx.mux.RLock()
+	defer idx.mux.RUnlock()
+
+	result := make([]TopQuery, 0, len(idx.counters))
+	for _, c := range idx.counters {
+		result = append(result, TopQuery{Query: c.query, Count: c.count, Error: c.err})
+	}
+	return result
+}
+
+// Range calls f for each monitored query and its count
+// while f returns true.
+func (idx *topKIndex) Range(f func(query string, count int) bool) {
+	idx.mux.RLock()
+	defer idx.mux.RUnlock()
+
+	for _, c := range idx.counters {
+		if !f(c.query, c.count) {
+			return
+		}
+	}
+}
+
+// Close implements io.Closer interface, there is no indexation to stop.
+func (idx *topKIndex) Close() error {
+	return nil
+}
+
+// topKHeap is a min-heap of topKCounter by their counts.
+type topKHeap []*topKCounter
+
+func (h topKHeap) Len() int           { return len(h) }
+func (h topKHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h topKHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].heapIndex = i
+	h[j].heapIndex = j
+}
+func (h *topKHeap) Push(x interface{}) {
+	c := x.(*topKCounter)
+	c.heapIndex = len(*h)
+	*h = append(*h, c)
+}
+func (h *topKHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
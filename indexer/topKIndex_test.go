@@ -0,0 +1,102 @@
+package indexer_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cosaques/algolia/indexer"
+)
+
+func TestTopKIndexTop(t *testing.T) {
+	// Capacity is enough to monitor all the queries, so counts are exact.
+	idx := indexer.NewTopKIndex(10, 0.01)
+	index(idx, 10, 10)
+	tops := idx.Top(3)
+	for i, top := range tops {
+		want := indexer.TopQuery{Query: fmt.Sprintf("Query %d", 10-i), Count: 10 * (10 - i)}
+		if top != want {
+			t.Errorf("Top %d = %v, want %v (%T)", i+1, top, want, idx)
+		}
+	}
+	if idx.Len() != 10 {
+		t.Errorf("Index len = %d, want %d (%T)", idx.Len(), 10, idx)
+	}
+}
+
+func TestTopKIndexErrorBounds(t *testing.T) {
+	// Query i occurs i times, interleaved with 1000 queries occurring once.
+	counts := make(map[string]int)
+	idx := indexer.NewTopKIndex(20, 0.01)
+	for i := 1; i <= 50; i++ {
+		for j := 0; j < i; j++ {
+			query := fmt.Sprintf("Query %d", i)
+			idx.Add(query)
+			counts[query]++
+
+			rare := fmt.Sprintf("Rare %d-%d", i, j)
+			if len(counts) < 1050 {
+				idx.Add(rare)
+				counts[rare]++
+			}
+		}
+	}
+
+	tops := idx.Top(5)
+	if len(tops) != 5 {
+		t.Fatalf("Top len = %d, want %d (%T)", len(tops), 5, idx)
+	}
+	for i, top := range tops {
+		// Real count is between Count-Error and Count.
+		if actual := counts[top.Query]; actual > top.Count || actual < top.Count-top.Error {
+			t.Errorf("Top %d = %v, real count %d out of bounds", i+1, top, actual)
+		}
+	}
+	if want := "Query 50"; tops[0].Query != want {
+		t.Errorf("Top 1 = %v, want %q", tops[0], want)
+	}
+}
+
+func TestTopKIndexMerge(t *testing.T) {
+	// "b" replaces "a" in src, so its count 2 is overestimated by 1.
+	src := indexer.NewTopKIndex(1, 0.01)
+	src.Add("a")
+	src.Add("b")
+	dst := indexer.NewTopKIndex(10, 0.01)
+	dst.Add("b")
+
+	if err := indexer.Merge(dst, src); err != nil {
+		t.Fatalf("Error %v occured", err)
+	}
+	want := indexer.TopQuery{Query: "b", Count: 3, Error: 1}
+	if top := dst.Top(1); len(top) != 1 || top[0] != want {
+		t.Errorf("Top(1) = %v, want %v", top, want)
+	}
+	if err := indexer.Merge(dst, dst); err == nil {
+		t.Errorf("Merge() of an index into itself should fail")
+	}
+}
+
+func TestAggregatorWithTopK(t *testing.T) {
+	aggregator := indexer.NewAggregator(indexer.WithTopK(1))
+	traces := []indexer.Trace{
+		{time.Date(2015, 8, 1, 0, 3, 43, 0, time.UTC), "q1"},
+		{time.Date(2015, 8, 1, 0, 3, 43, 0, time.UTC), "q1"},
+		{time.Date(2015, 8, 1, 1, 3, 44, 0, time.UTC), "q2"},
+	}
+	for _, trace := range traces {
+		aggregator.Add(trace)
+	}
+
+	r, _ := indexer.ParseTimeRange("2015-08")
+	idx, _ := aggregator.GetIndex(r)
+	if _, ok := idx.(indexer.Estimator); !ok {
+		t.Fatalf("GetIndex(%q) is %T, want an Estimator", r, idx)
+	}
+	if idx.Len() != 2 {
+		t.Errorf("GetIndex(%q).Len() = %d, want %d", r, idx.Len(), 2)
+	}
+	if top, want := idx.Top(2), (indexer.TopQuery{Query: "q2", Count: 3, Error: 2}); len(top) != 1 || top[0] != want {
+		t.Errorf("GetIndex(%q).Top(2) = %v, want [%v]", r, top, want)
+	}
+}
@@ -1,9 +1,12 @@
 package indexer
 
 import (
+	"context"
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
+	"strings"
 	"time"
 )
 
@@ -19,41 +22,106 @@ type (
 		Date  time.Time
 		Query string
 	}
+
+	// TraceError is returned when a line of a log file can't be read as a Trace.
+	TraceError struct {
+		// Line is the number of the line, starting from 1.
+		Line int
+		// Record is the content of the line if it could be read.
+		Record string
+		Err    error
+	}
 )
 
-// tsvTraceReader reads traces from a tsv file.
-type tsvTraceReader struct {
-	tsvReader *csv.Reader
+// Error implements error interface.
+func (e *TraceError) Error() string {
+	return fmt.Sprintf("traceReader.Read(): line %d: %v.", e.Line, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *TraceError) Unwrap() error {
+	return e.Err
+}
+
+// csvTraceReader reads traces from a csv or a tsv file.
+type csvTraceReader struct {
+	csvReader *csv.Reader
+	comma     rune
+	options   ReaderOptions
 }
 
-// NewTraceReader creates a new instance of tsvTraceReader.
+// NewTraceReader creates a new instance of csvTraceReader reading a tsv file
+// with 2 fields per line: a date and a query.
 func NewTraceReader(tsvFile io.Reader) TraceReader {
-	csvReader := csv.NewReader(tsvFile)
-	csvReader.Comma = '\t'
-	return &tsvTraceReader{
-		tsvReader: csvReader,
+	return newCSVTraceReader(tsvFile, '\t', DefaultReaderOptions)
+}
+
+// newCSVTraceReader creates a new instance of csvTraceReader.
+func newCSVTraceReader(file io.Reader, comma rune, options ReaderOptions) TraceReader {
+	csvReader := csv.NewReader(file)
+	csvReader.Comma = comma
+	// Wrong number of fields is reported by parseRecord.
+	csvReader.FieldsPerRecord = -1
+	return &csvTraceReader{
+		csvReader: csvReader,
+		comma:     comma,
+		options:   options,
 	}
 }
 
 // Read reads the traces one by one.
-func (t *tsvTraceReader) Read() (Trace, error) {
-	// Read next record from a tsv file.
-	csvRecord, err := t.tsvReader.Read()
-	if err != nil {
+func (t *csvTraceReader) Read() (Trace, error) {
+	// Read next record from a csv file.
+	csvRecord, err := t.csvReader.Read()
+	if errors.Is(err, io.EOF) {
 		return Trace{}, fmt.Errorf("traceReader.Read(): %w.", err)
 	}
 
-	// Only to fields expected: date and query.
-	if len(csvRecord) != 2 {
-		return Trace{}, fmt.Errorf("traceReader.Read(): line should contain 2 args %v.", csvRecord)
+	// Only a malformed record is skipped, the rest of the file can't be read after other errors.
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return Trace{}, &TraceError{Line: parseErr.StartLine, Err: err}
+	} else if err != nil {
+		return Trace{}, fmt.Errorf("traceReader.Read(): %w.", err)
 	}
 
-	// Parse date.
-	date, err := time.Parse("2006-01-02 15:04:05", csvRecord[0])
+	// Define the line of the record to report errors.
+	line, _ := t.csvReader.FieldPos(0)
+
+	trace, err := t.options.parseRecord(csvRecord)
 	if err != nil {
-		return Trace{}, fmt.Errorf("traceReader.Read(): %w.", err)
+		return Trace{}, &TraceError{Line: line, Record: strings.Join(csvRecord, string(t.comma)), Err: err}
 	}
+	return trace, nil
+}
+
+// contextTraceReader stops reading traces once a context is done.
+type contextTraceReader struct {
+	ctx         context.Context
+	traceReader TraceReader
+}
+
+// NewContextTraceReader wraps a TraceReader whose Read returns ctx.Err() once ctx is done,
+// even if the underlying reader is ended by ctx too (e.g. a file followed with ctx).
+func NewContextTraceReader(ctx context.Context, traceReader TraceReader) TraceReader {
+	return &contextTraceReader{ctx: ctx, traceReader: traceReader}
+}
+
+// Read reads the traces one by one till ctx is done.
+func (r *contextTraceReader) Read() (Trace, error) {
+	if err := r.ctx.Err(); err != nil {
+		return Trace{}, err
+	}
+
+	trace, err := r.traceReader.Read()
+	if errors.Is(err, io.EOF) && r.ctx.Err() != nil {
+		return Trace{}, r.ctx.Err()
+	}
+	return trace, err
+}
 
-	// Construct a Trace.
-	return Trace{Date: date, Query: csvRecord[1]}, nil
+// ParseTrace parses the fields of a line in a log file of queries
+// with DefaultReaderOptions.
+func ParseTrace(fields []string) (Trace, error) {
+	return DefaultReaderOptions.parseRecord(fields)
 }
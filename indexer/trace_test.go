@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	"github.com/cosaques/algolia/indexer"
@@ -39,3 +41,79 @@ func TestTraceRead(t *testing.T) {
 		t.Fatalf("Get error %v, want EOF", err)
 	}
 }
+
+func TestTraceReadErrors(t *testing.T) {
+	tsv := "2015-08-01 00:04:00\tq1\n" +
+		"2015-08-01 00:04:01\tq2\textra\n" +
+		"2015-08-01T00:04:02\tq3\n" +
+		"2015-08-01 00:04:03\t\"q4\n" +
+		"\n" +
+		"2015-08-01 00:04:04\tq5\n"
+
+	traceReader := indexer.NewTraceReader(strings.NewReader(tsv))
+	want := []struct {
+		query string
+		line  int
+	}{
+		{"q1", 0},
+		{"", 2},
+		{"", 3},
+		{"", 4},
+	}
+	for i, w := range want {
+		t.Run(fmt.Sprintf("Line %d", i+1), func(t *testing.T) {
+			trace, err := traceReader.Read()
+			if w.line == 0 {
+				if err != nil || trace.Query != w.query {
+					t.Fatalf("Get trace %v, error %v, want %q", trace, err, w.query)
+				}
+				return
+			}
+
+			var traceErr *indexer.TraceError
+			if !errors.As(err, &traceErr) {
+				t.Fatalf("Get error %v, want a TraceError", err)
+			}
+			if traceErr.Line != w.line {
+				t.Errorf("Get error at line %d, want %d", traceErr.Line, w.line)
+			}
+		})
+	}
+}
+
+func TestTraceReadFailure(t *testing.T) {
+	readErr := errors.New("connection reset")
+	traceReader := indexer.NewTraceReader(io.MultiReader(strings.NewReader("2015-08-01 00:04:00\tq1\n"), iotest.ErrReader(readErr)))
+	if _, err := traceReader.Read(); err != nil {
+		t.Fatalf("Error %v occured", err)
+	}
+	_, err := traceReader.Read()
+	var traceErr *indexer.TraceError
+	if !errors.Is(err, readErr) || errors.As(err, &traceErr) {
+		t.Errorf("Get error %v, want %v not being a TraceError", err, readErr)
+	}
+}
+
+func TestParseTrace(t *testing.T) {
+	tests := []struct {
+		name    string
+		fields  []string
+		want    indexer.Trace
+		wantErr bool
+	}{
+		{"Valid", []string{"2015-08-01 00:04:00", "q1"}, indexer.Trace{time.Date(2015, 8, 1, 0, 4, 0, 0, time.UTC), "q1"}, false},
+		{"WrongDate", []string{"2015-08-01", "q1"}, indexer.Trace{}, true},
+		{"WrongFields", []string{"2015-08-01 00:04:00"}, indexer.Trace{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := indexer.ParseTrace(tt.fields)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTrace() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseTrace() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package indexer
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// TrendMethod is the way the growth of a query is scored
+// (Delta, Ratio, ZScore).
+type TrendMethod int
+
+const (
+	// Delta scores a query by count - previous count.
+	Delta TrendMethod = 1 + iota
+	// Ratio scores a query by (count + 1) / (previous count + 1),
+	// so that new queries don't get an infinite score.
+	Ratio
+	// ZScore scores a query by (count - previous count) / sqrt(previous count + 1),
+	// the growth in standard deviations of a Poisson count smoothed by 1,
+	// so that the growth of rare queries weighs less than with Ratio.
+	ZScore
+)
+
+// trendMethodNames are the names of methods, e.g. used in URLs.
+var trendMethodNames = map[TrendMethod]string{
+	Delta:  "delta",
+	Ratio:  "ratio",
+	ZScore: "zscore",
+}
+
+// String returns the name of a method.
+func (m TrendMethod) String() string {
+	if name, ok := trendMethodNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("TrendMethod(%d)", int(m))
+}
+
+// ParseTrendMethod parses a method from its name, e.g. "zscore".
+func ParseTrendMethod(value string) (TrendMethod, error) {
+	for method, name := range trendMethodNames {
+		if name == value {
+			return method, nil
+		}
+	}
+	return 0, fmt.Errorf("ParseTrendMethod: Unknown method %q.", value)
+}
+
+// score returns the score of a query growing from previous to count.
+func (m TrendMethod) score(count, previous int) float64 {
+	switch m {
+	case Ratio:
+		return float64(count+1) / float64(previous+1)
+	case ZScore:
+		return float64(count-previous) / math.Sqrt(float64(previous+1))
+	default:
+		return float64(count - previous)
+	}
+}
+
+// TrendingQuery represents a query whose count grew from PreviousCount to Count.
+type TrendingQuery struct {
+	Query         string
+	Count         int
+	PreviousCount int
+	Score         float64
+}
+
+// trending returns the queries whose count grew the most from previous to current,
+// the highest score first, at most size of them (all of them if size < 0).
+// Either index could be nil when there is no query.
+func trending(current, previous Index, method TrendMethod, size int) []TrendingQuery {
+	result := []TrendingQuery{}
+	if current == nil {
+		return result
+	}
+
+	// Get the previous counts at once unless they are looked up faster.
+	previousCount := func(string) int { return 0 }
+	if counter, ok := previous.(QueryCounter); ok {
+		previousCount = counter.Count
+	} else if previous != nil {
+		counts := make(map[string]int)
+		previous.Range(func(query string, count int) bool {
+			counts[query] = count
+			return true
+		})
+		previousCount = func(query string) int { return counts[query] }
+	}
+
+	current.Range(func(query string, count int) bool {
+		if previous := previousCount(query); count > previous {
+			result = append(result, TrendingQuery{Query: query, Count: count, PreviousCount: previous, Score: method.score(count, previous)})
+		}
+		return true
+	})
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Score != result[j].Score {
+			return result[i].Score > result[j].Score
+		}
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Query < result[j].Query
+	})
+	if size >= 0 && size < len(result) {
+		result = result[:size]
+	}
+	return result
+}
@@ -0,0 +1,77 @@
+package indexer_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cosaques/algolia/indexer"
+)
+
+func TestAggregatorTrending(t *testing.T) {
+	aggregator := indexer.NewAggregator()
+	// Counts of queries on 2015-08-01 and 2015-08-02.
+	for query, counts := range map[string][2]int{
+		"stable":  {10, 10},
+		"falling": {10, 2},
+		"popular": {100, 130},
+		"rare":    {1, 10},
+		"new":     {0, 5},
+	} {
+		for day, count := range counts {
+			for i := 0; i < count; i++ {
+				aggregator.Add(indexer.Trace{time.Date(2015, 8, 1+day, 0, 0, i%60, 0, time.UTC), query})
+			}
+		}
+	}
+
+	tests := []struct {
+		method indexer.TrendMethod
+		want   string
+	}{
+		{indexer.Delta, "[popular(100->130) rare(1->10) new(0->5)]"},
+		{indexer.Ratio, "[new(0->5) rare(1->10) popular(100->130)]"},
+		{indexer.ZScore, "[rare(1->10) new(0->5) popular(100->130)]"},
+	}
+	day, _ := indexer.ParseTimeRange("2015-08-02")
+	for _, tt := range tests {
+		t.Run(tt.method.String(), func(t *testing.T) {
+			result, err := aggregator.Trending(day, tt.method, 10)
+			if err != nil {
+				t.Fatalf("Error %v occured", err)
+			}
+			var got []string
+			for i, q := range result {
+				got = append(got, fmt.Sprintf("%s(%d->%d)", q.Query, q.PreviousCount, q.Count))
+				if i > 0 && result[i-1].Score < q.Score {
+					t.Errorf("Trending %d = %v is after %v", i+1, q, result[i-1])
+				}
+			}
+			if fmt.Sprint(got) != tt.want {
+				t.Errorf("Trending(%v) = %v, want %s", tt.method, got, tt.want)
+			}
+		})
+	}
+
+	// Without previous queries all the queries are trending.
+	first, _ := indexer.ParseTimeRange("2015-08-01")
+	if result, err := aggregator.Trending(first, indexer.Delta, 2); err != nil || len(result) != 2 || result[0].Query != "popular" || result[0].Score != 100 {
+		t.Errorf("Trending(%v) = %v, %v, want popular first", first, result, err)
+	}
+	// Without queries none is.
+	empty, _ := indexer.ParseTimeRange("2015-09-01")
+	if result, err := aggregator.Trending(empty, indexer.Delta, 2); err != nil || len(result) != 0 {
+		t.Errorf("Trending(%v) = %v, %v, want none", empty, result, err)
+	}
+}
+
+func TestParseTrendMethod(t *testing.T) {
+	for _, method := range []indexer.TrendMethod{indexer.Delta, indexer.Ratio, indexer.ZScore} {
+		if got, err := indexer.ParseTrendMethod(method.String()); err != nil || got != method {
+			t.Errorf("ParseTrendMethod(%q) = %v, %v, want %v", method.String(), got, err, method)
+		}
+	}
+	if _, err := indexer.ParseTrendMethod("mean"); err == nil {
+		t.Errorf("ParseTrendMethod(%q) should fail", "mean")
+	}
+}
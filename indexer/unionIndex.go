@@ -0,0 +1,117 @@
+package indexer
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrReadOnly is reported when queries are added to a read-only index,
+// e.g. one returned by Aggregator.GetRange.
+var ErrReadOnly = errors.New("Index is read-only")
+
+// unionIndex is a read-only view merging several indexes
+// covering disjoint time ranges.
+type unionIndex struct {
+	indexes []Index
+	// added is set once a query is added, which is ignored.
+	added int32
+}
+
+// newUnionIndex creates an instance of unionIndex.
+func newUnionIndex(indexes []Index) Index {
+	return &unionIndex{indexes: indexes}
+}
+
+// Add is ignored since a union is a read-only view of its indexes,
+// Err then returns ErrReadOnly.
+func (u *unionIndex) Add(string) {
+	atomic.StoreInt32(&u.added, 1)
+}
+
+// Merge returns ErrReadOnly, the queries of src aren't added.
+func (u *unionIndex) Merge(Index) error {
+	atomic.StoreInt32(&u.added, 1)
+	return ErrReadOnly
+}
+
+// Err returns ErrReadOnly if queries have been added to the union.
+func (u *unionIndex) Err() error {
+	if atomic.LoadInt32(&u.added) != 0 {
+		return ErrReadOnly
+	}
+	return nil
+}
+
+// Close implements io.Closer interface, the indexes of the union
+// are left open since they aren't owned by the view.
+func (u *unionIndex) Close() error {
+	return nil
+}
+
+// Len gets the count of distinct indexed queries.
+func (u *unionIndex) Len() int {
+	return len(u.counts())
+}
+
+// Top returns most popular queries.
+func (u *unionIndex) Top(size int) []TopQuery {
+	// sortTopQueries keeps all the queries for a negative size.
+	if size < 0 {
+		size = 0
+	}
+	counts := u.counts()
+
+	result := make([]TopQuery, 0, len(counts))
+	for query, count := range counts {
+		result = append(result, TopQuery{Query: query, Count: count})
+	}
+	return sortTopQueries(result, size)
+}
+
+// Count returns the count of a query among all the indexes.
+func (u *unionIndex) Count(query string) int {
+	result := 0
+	for _, idx := range u.indexes {
+		result += Count(idx, query)
+	}
+	return result
+}
+
+// Search returns the queries starting with prefix and containing contains,
+// the most popular first, at most size of them (all of them if size < 0).
+func (u *unionIndex) Search(prefix, contains string, size int) []TopQuery {
+	counts := make(map[string]int)
+	for _, idx := range u.indexes {
+		for _, q := range Search(idx, prefix, contains, -1) {
+			counts[q.Query] += q.Count
+		}
+	}
+
+	result := make([]TopQuery, 0, len(counts))
+	for query, count := range counts {
+		result = append(result, TopQuery{Query: query, Count: count})
+	}
+	return sortTopQueries(result, size)
+}
+
+// Range calls f for each indexed query and its count
+// while f returns true.
+func (u *unionIndex) Range(f func(query string, count int) bool) {
+	for query, count := range u.counts() {
+		if !f(query, count) {
+			return
+		}
+	}
+}
+
+// counts sums the counts of each query among all the indexes.
+func (u *unionIndex) counts() map[string]int {
+	counts := make(map[string]int)
+	for _, idx := range u.indexes {
+		idx.Range(func(query string, count int) bool {
+			counts[query] += count
+			return true
+		})
+	}
+	return counts
+}
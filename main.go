@@ -3,49 +3,84 @@ package main
 import (
 	"bufio"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"os"
 	"strconv"
-	"sync"
 
 	"github.com/cosaques/algolia/indexer"
 )
 
 func main() {
-	ch := make(chan struct{})
+	ch := make(chan int)
 	go func() {
 		var i int
 		for {
-			if _, ok := <-ch; !ok {
+			n, ok := <-ch
+			if !ok {
 				return
 			}
 
-			i++
+			i += n
 			fmt.Printf("\rIndexed %d", i)
 		}
 	}()
 
-	file, _ := os.Open("hn_logs.tsv")
+	filePath := flag.String("file", "hn_logs.tsv", "The path to the file containing logs (see -format), optionally compressed with gzip or zstd, \"-\" for the standard input")
+	onError := flag.String("on-error", "fail", "What to do with lines of the logs file which can't be read: \"fail\", \"skip\" or \"record\" them to -dead-letter")
+	deadLetterPath := flag.String("dead-letter", "rejected.tsv", "The path of the file lines rejected with -on-error=record are appended to")
+	readerOptions := indexer.DefaultReaderOptions
+	readerOptions.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	policy, err := indexer.ParseErrorPolicy(*onError)
+	if err != nil {
+		panic(err)
+	}
+
+	file, err := indexer.OpenTraceFile(*filePath)
+	if err != nil {
+		panic(err)
+	}
 	defer file.Close()
-	traceReader := indexer.NewTraceReader(file)
+	traceReader, err := indexer.NewTraceReaderWith(file, readerOptions)
+	if err != nil {
+		panic(err)
+	}
+	var deadLetter io.Writer
+	if policy == indexer.RecordOnError {
+		deadLetterFile, err := os.OpenFile(*deadLetterPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			panic(err)
+		}
+		defer deadLetterFile.Close()
+		deadLetter = deadLetterFile
+	}
+	var rejected int
+	traceReader = indexer.NewLenientTraceReader(traceReader, policy, deadLetter, func(*indexer.TraceError) {
+		rejected++
+	})
 	aggregator := indexer.NewAggregator()
-	var wg sync.WaitGroup
+	pipelineOptions := indexer.DefaultPipelineOptions
+	pipelineOptions.OnAdded = func(n int) {
+		ch <- n
+	}
+	pipeline := indexer.NewPipeline(aggregator, pipelineOptions)
 	for trace, err := traceReader.Read(); !errors.Is(err, io.EOF); trace, err = traceReader.Read() {
 		if err != nil {
-			panic(err)
+			fmt.Fprintln(os.Stderr, "\n"+err.Error())
+			os.Exit(1)
 		}
-		wg.Add(1)
-		go func(trace indexer.Trace) {
-			defer wg.Done()
-			aggregator.Add(trace)
-			ch <- struct{}{}
-		}(trace)
+		pipeline.Add(trace)
 	}
-	wg.Wait()
+	pipeline.Close()
 	close(ch)
 
 	fmt.Println("\nCompleted!")
+	if rejected > 0 {
+		fmt.Printf("Rejected %d lines\n", rejected)
+	}
 
 	scanner := bufio.NewScanner(os.Stdin)
 	for {
@@ -59,7 +94,9 @@ func main() {
 			scanner.Scan()
 			date := scanner.Text()
 			tr, _ := indexer.ParseTimeRange(date)
-			if idx := aggregator.GetIndex(tr); idx != nil {
+			if idx, err := aggregator.GetIndex(tr); err != nil {
+				fmt.Println(err)
+			} else if idx != nil {
 				fmt.Println(idx.Len())
 			} else {
 				fmt.Println(0)
@@ -74,7 +111,9 @@ func main() {
 			scanner.Scan()
 			date := scanner.Text()
 			tr, _ := indexer.ParseTimeRange(date)
-			if idx := aggregator.GetIndex(tr); idx != nil {
+			if idx, err := aggregator.GetIndex(tr); err != nil {
+				fmt.Println(err)
+			} else if idx != nil {
 				fmt.Println(idx.Top(size))
 			} else {
 				fmt.Println("[]")
@@ -1,13 +1,14 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
-	"os"
 	"strconv"
 	"strings"
 	"sync"
@@ -24,12 +25,24 @@ type aggregatorHandler struct {
 	aggregator indexer.Aggregator
 	// handledCount keeps number of handled logs.
 	handledCount int32
+	// rejectedCount keeps number of logs which can't be read.
+	rejectedCount int32
+	// location is the time zone of <DATE_PREFIX> when there is no "tz" parameter.
+	location *time.Location
+	// shutdown is closed when the server shuts down, closing the monitoring sockets.
+	shutdown <-chan struct{}
+	// maxBodySize is the maximum size in bytes of the request bodies of traces.
+	maxBodySize int64
+	// snapshotMux allows to save snapshots one at a time.
+	snapshotMux sync.Mutex
 }
 
 // newAggregatorHandler creates a new instance of aggregatorHandler
-func newAggregatorHandler() *aggregatorHandler {
+func newAggregatorHandler(options ...indexer.Option) *aggregatorHandler {
 	return &aggregatorHandler{
-		aggregator: indexer.NewAggregator(),
+		aggregator:  indexer.NewAggregator(options...),
+		location:    time.UTC,
+		maxBodySize: defaultMaxBodySize,
 	}
 }
 
@@ -40,84 +53,287 @@ func (h *aggregatorHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	action := segs[3]
 
 	switch action {
-	// /1/queries/count/<DATE_PREFIX>
+	// /1/queries/count/<DATE_PREFIX> or /1/queries/count?from=<FROM>&to=<TO>
 	case "count":
-		// URL should contain <DATE_PREFIX> as well.
-		if len(segs) != 5 {
-			http.Error(w, "Wrong URL", http.StatusNotFound)
+		queryValues, err := url.ParseQuery(r.URL.RawQuery)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		// Check if TimeRange (<DATE_PREFIX>) is valid.
-		timeRange, err := indexer.ParseTimeRange(segs[4])
+		idx, err := h.getIndex(segs, queryValues)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeIndexError(w, err)
 			return
 		}
 
-		h.handleCount(timeRange, w, r)
-	// /1/queries/popular/<DATE_PREFIX>?size=<SIZE>
-	case "popular":
-		// URL should contain <DATE_PREFIX> as well.
-		if len(segs) != 5 {
-			http.Error(w, "Wrong URL", http.StatusNotFound)
+		h.handleCount(idx, w, r)
+	// /1/queries/popular/<DATE_PREFIX>?size=<SIZE> or /1/queries/popular?from=<FROM>&to=<TO>&size=<SIZE>
+	// /1/queries/search/<DATE_PREFIX>?prefix=<PREFIX>&contains=<CONTAINS>&size=<SIZE> or
+	// /1/queries/search?from=<FROM>&to=<TO>&prefix=<PREFIX>&contains=<CONTAINS>&size=<SIZE>
+	case "popular", "search":
+		queryValues, err := url.ParseQuery(r.URL.RawQuery)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		// Check if TimeRange (<DATE_PREFIX>) is valid.
-		timeRange, err := indexer.ParseTimeRange(segs[4])
+		idx, err := h.getIndex(segs, queryValues)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeIndexError(w, err)
 			return
 		}
 
 		// Try to get a "size" parameter.
+		size, err := parseSize(queryValues)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if action == "search" {
+			h.handleSearch(idx, queryValues.Get("prefix"), queryValues.Get("contains"), size, w, r)
+			return
+		}
+		h.handlePopular(idx, size, w, r)
+	// /1/queries/series/<DATE_PREFIX>?query=<QUERY>&step=<STEP> or
+	// /1/queries/series?from=<FROM>&to=<TO>&query=<QUERY>&step=<STEP>
+	case "series":
 		queryValues, err := url.ParseQuery(r.URL.RawQuery)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		if len(queryValues["size"]) == 0 {
-			http.Error(w, "Query should contain a \"size\" parameter", http.StatusBadRequest)
+		if queryValues.Get("query") == "" || queryValues.Get("step") == "" {
+			http.Error(w, "Query should contain \"query\" and \"step\" parameters", http.StatusBadRequest)
 			return
 		}
-		size, err := strconv.Atoi(queryValues["size"][0])
+		step, err := indexer.ParseTimePrecision(queryValues.Get("step"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		from, to, err := h.getBounds(segs, queryValues)
+		if err != nil {
+			writeIndexError(w, err)
+			return
+		}
+		points, err := h.aggregator.Series(queryValues.Get("query"), from, to, step)
+		if err != nil {
+			writeIndexError(w, err)
+			return
+		}
+
+		resp := SeriesResponse{Query: queryValues.Get("query"), Step: step.String(), Points: make([]SeriesPointResponse, len(points))}
+		for i, point := range points {
+			resp.Points[i] = SeriesPointResponse{Date: point.Range.String(), Count: point.Count}
+		}
+		writeJSON(w, resp)
+	// /1/queries/trending/<DATE_PREFIX>?size=<SIZE>&method=<METHOD>
+	case "trending":
+		queryValues, err := url.ParseQuery(r.URL.RawQuery)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		size, method, err := parseTrendingParams(queryValues)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-		h.handlePopular(timeRange, size, w, r)
+		// The previous time range is only defined for a <DATE_PREFIX>.
+		timeRange, _, _, err := h.parseTimeRange(segs, queryValues)
+		if err == nil && timeRange == nil {
+			err = errWrongURL
+		}
+		if err != nil {
+			writeIndexError(w, err)
+			return
+		}
+		result, err := h.aggregator.Trending(*timeRange, method, size)
+		if err != nil {
+			writeIndexError(w, err)
+			return
+		}
+
+		resp := TrendingResponse{Method: method.String(), Queries: make([]TrendingQueryResponse, len(result))}
+		for i, q := range result {
+			resp.Queries[i] = TrendingQueryResponse{Query: q.Query, Count: q.Count, PreviousCount: q.PreviousCount, Score: q.Score}
+		}
+		writeJSON(w, resp)
+	// /1/queries/variants?query=<QUERY>
+	case "variants":
+		query := r.URL.Query().Get("query")
+		if query == "" {
+			http.Error(w, "Query should contain a \"query\" parameter", http.StatusBadRequest)
+			return
+		}
+
+		resp := VariantsResponse{Query: query, Variants: []QueryCountResponse{}}
+		for _, variant := range h.aggregator.Variants(query) {
+			resp.Variants = append(resp.Variants, QueryCountResponse{Query: variant.Query, Count: variant.Count})
+		}
+		writeJSON(w, resp)
 	// /1/queries/monitoring
 	case "monitoring":
 		h.handleMonitor(w, r)
+	// POST /1/queries/traces
+	case "traces":
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		h.handleTraces(w, r)
 	}
 }
 
-// handleCount returns count of distinct queries for a given time range.
-func (h *aggregatorHandler) handleCount(timeRange indexer.TimeRange, w http.ResponseWriter, r *http.Request) {
-	result := 0
-	if idx := h.aggregator.GetIndex(timeRange); idx != nil {
-		result = idx.Len()
+var (
+	// errWrongURL is returned when the URL doesn't match any API action.
+	errWrongURL = errors.New("Wrong URL")
+	// errWrongParameter is returned when a parameter of the URL is invalid.
+	errWrongParameter = errors.New("Wrong parameter")
+)
+
+// writeIndexError responds with the status matching an error getting indexes.
+func writeIndexError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errWrongURL):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, errWrongParameter), errors.Is(err, indexer.ErrPrecisionNotIndexed), errors.Is(err, indexer.ErrInvalidStep):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// getIndex returns the index either for a <DATE_PREFIX> of the URL
+// or for a time range given by "from" and "to" parameters.
+func (h *aggregatorHandler) getIndex(segs []string, queryValues url.Values) (indexer.Index, error) {
+	timeRange, from, to, err := h.parseTimeRange(segs, queryValues)
+	if err != nil {
+		return nil, err
+	}
+
+	if timeRange != nil {
+		return h.aggregator.GetIndex(*timeRange)
 	}
+	return h.aggregator.GetRange(from, to)
+}
+
+// getBounds returns the bounds [from, to) either of a <DATE_PREFIX> of the URL
+// or of a time range given by "from" and "to" parameters.
+func (h *aggregatorHandler) getBounds(segs []string, queryValues url.Values) (from, to time.Time, err error) {
+	timeRange, from, to, err := h.parseTimeRange(segs, queryValues)
+	if err != nil || timeRange == nil {
+		return from, to, err
+	}
+	return timeRange.Start(), timeRange.End(), nil
+}
 
-	resp := CountResponse{Count: result}
+// parseTimeRange parses either a <DATE_PREFIX> of the URL into timeRange
+// or a time range given by "from" and "to" parameters into [from, to).
+// Dates are in the time zone of the "tz" parameter if any.
+func (h *aggregatorHandler) parseTimeRange(segs []string, queryValues url.Values) (timeRange *indexer.TimeRange, from, to time.Time, err error) {
+	loc := h.location
+	if len(queryValues["tz"]) > 0 {
+		if loc, err = time.LoadLocation(queryValues["tz"][0]); err != nil {
+			return nil, from, to, fmt.Errorf("%w \"tz\": %v", errWrongParameter, err)
+		}
+	}
+
+	switch len(segs) {
+	// URL contains <DATE_PREFIX>.
+	case 5:
+		// Check if TimeRange (<DATE_PREFIX>) is valid.
+		r, err := indexer.ParseTimeRangeIn(segs[4], loc)
+		if err != nil {
+			return nil, from, to, err
+		}
+
+		return &r, from, to, nil
+	// URL contains "from" and "to" parameters instead.
+	case 4:
+		if len(queryValues["from"]) == 0 || len(queryValues["to"]) == 0 {
+			return nil, from, to, errWrongURL
+		}
+
+		// Both bounds are date prefixes, "to" is excluded from the range.
+		fromRange, err := indexer.ParseTimeRangeIn(queryValues["from"][0], loc)
+		if err != nil {
+			return nil, from, to, err
+		}
+		toRange, err := indexer.ParseTimeRangeIn(queryValues["to"][0], loc)
+		if err != nil {
+			return nil, from, to, err
+		}
+		if !fromRange.Start().Before(toRange.Start()) {
+			return nil, from, to, fmt.Errorf("%w \"from\": %s isn't before %s", errWrongParameter, fromRange, toRange)
+		}
+
+		return nil, fromRange.Start(), toRange.Start(), nil
+	default:
+		return nil, from, to, errWrongURL
+	}
+}
+
+// parseSize returns the "size" parameter, which can't be negative.
+func parseSize(queryValues url.Values) (int, error) {
+	if len(queryValues["size"]) == 0 {
+		return 0, errors.New("Query should contain a \"size\" parameter")
+	}
+	size, err := strconv.Atoi(queryValues["size"][0])
+	if err != nil {
+		return 0, err
+	} else if size < 0 {
+		return 0, errors.New("The \"size\" parameter can't be negative")
+	}
+	return size, nil
+}
+
+// parseTrendingParams returns the "size" parameter and the "method" one, Delta by default.
+func parseTrendingParams(queryValues url.Values) (size int, method indexer.TrendMethod, err error) {
+	if size, err = parseSize(queryValues); err != nil {
+		return 0, 0, err
+	}
+
+	method = indexer.Delta
+	if name := queryValues.Get("method"); name != "" {
+		if method, err = indexer.ParseTrendMethod(name); err != nil {
+			return 0, 0, err
+		}
+	}
+	return size, method, nil
+}
+
+// handleCount returns count of distinct queries of a given index.
+func (h *aggregatorHandler) handleCount(idx indexer.Index, w http.ResponseWriter, r *http.Request) {
+	resp := CountResponse{}
+	if idx != nil {
+		resp.Count = idx.Len()
+		if estimator, ok := idx.(indexer.Estimator); ok {
+			resp.Approximate = true
+			resp.ErrorRate = estimator.ErrorRate()
+		}
+	}
 
 	w.WriteHeader(200)
 	w.Header().Set("content-type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
-// handlePopular returns top queries for a given time range.
-func (h *aggregatorHandler) handlePopular(timeRange indexer.TimeRange, size int, w http.ResponseWriter, r *http.Request) {
+// handlePopular returns top queries of a given index.
+func (h *aggregatorHandler) handlePopular(idx indexer.Index, size int, w http.ResponseWriter, r *http.Request) {
 	var result []indexer.TopQuery
-	if idx := h.aggregator.GetIndex(timeRange); idx != nil {
+	if idx != nil {
 		result = idx.Top(size)
 	}
 
 	resp := PopularResponse{Queries: make([]QueryCountResponse, len(result))}
 	for i, r := range result {
-		resp.Queries[i] = QueryCountResponse{Query: r.Query, Count: r.Count}
+		resp.Queries[i] = QueryCountResponse{Query: r.Query, Count: r.Count, Error: r.Error}
 	}
 
 	w.WriteHeader(200)
@@ -125,16 +341,86 @@ func (h *aggregatorHandler) handlePopular(timeRange indexer.TimeRange, size int,
 	json.NewEncoder(w).Encode(resp)
 }
 
+// handleSearch returns the most popular queries of a given index
+// starting with prefix and containing contains.
+func (h *aggregatorHandler) handleSearch(idx indexer.Index, prefix, contains string, size int, w http.ResponseWriter, r *http.Request) {
+	var result []indexer.TopQuery
+	if idx != nil {
+		result = indexer.Search(idx, prefix, contains, size)
+	}
+
+	resp := PopularResponse{Queries: make([]QueryCountResponse, len(result))}
+	for i, r := range result {
+		resp.Queries[i] = QueryCountResponse{Query: r.Query, Count: r.Count, Error: r.Error}
+	}
+
+	writeJSON(w, resp)
+}
+
+// handleTraces indexes query traces sent either as a tsv file or as a JSON array
+// of {date, query} objects, rejecting the invalid ones.
+func (h *aggregatorHandler) handleTraces(w http.ResponseWriter, r *http.Request) {
+	// The body is bounded before being decompressed.
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxBodySize)
+	traceReader, body, err := newRequestTraceReader(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer body.Close()
+
+	// Index query traces by batches, all of them being indexed before responding.
+	pipelineOptions := indexer.DefaultPipelineOptions
+	pipelineOptions.FlushInterval = 0
+	pipelineOptions.OnAdded = func(n int) {
+		atomic.AddInt32(&h.handledCount, int32(n))
+	}
+	pipeline := indexer.NewPipeline(h.aggregator, pipelineOptions)
+
+	resp := TracesResponse{}
+	status := http.StatusOK
+	for trace, err := traceReader.Read(); !errors.Is(err, io.EOF); trace, err = traceReader.Read() {
+		var traceErr *indexer.TraceError
+		if errors.As(err, &traceErr) {
+			// Only this query trace is rejected.
+			resp.Errors = append(resp.Errors, TraceErrorResponse{Line: traceErr.Line, Error: traceErr.Err.Error()})
+			atomic.AddInt32(&h.rejectedCount, 1)
+			continue
+		} else if err != nil {
+			// The rest of query traces can't be read.
+			resp.Errors = append(resp.Errors, TraceErrorResponse{Error: err.Error()})
+			status = http.StatusBadRequest
+			break
+		}
+
+		pipeline.Add(trace)
+		resp.Accepted++
+	}
+	pipeline.Close()
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
 // some constants and vars allowing to handle a socket connection
 const (
 	socketBufferSize  = 1024
 	messageBufferSize = 256
+	// closeTimeout is the time given to clients to close a socket connection at shutdown.
+	closeTimeout = time.Second
 )
 
 var upgrader = &websocket.Upgrader{ReadBufferSize: socketBufferSize, WriteBufferSize: socketBufferSize}
 
 // handleMonitor sends the actual number of indexed query traces via a socket connection.
 func (h *aggregatorHandler) handleMonitor(w http.ResponseWriter, r *http.Request) {
+	serveMonitor(h.shutdown, &h.handledCount, &h.rejectedCount, w, r)
+}
+
+// serveMonitor sends the actual number of handled and rejected query traces via a socket connection
+// till the client disconnects or shutdown is closed.
+func serveMonitor(shutdown <-chan struct{}, handledCount, rejectedCount *int32, w http.ResponseWriter, r *http.Request) {
 	// Upgrade the request to a socket connection.
 	socket, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -142,25 +428,43 @@ func (h *aggregatorHandler) handleMonitor(w http.ResponseWriter, r *http.Request
 		return
 	}
 	defer socket.Close()
+	disconnected := make(chan struct{})
+	defer close(disconnected)
 
 	// Send an actual index state to a socket with a given periodicity.
 	go func() {
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+
 		msg := MonitoringMsg{}
 		for {
-			// Get a handledCount in a correct concurrent way.
-			handledCount := int(atomic.LoadInt32(&h.handledCount))
+			// Get counters in a correct concurrent way.
+			count := int(atomic.LoadInt32(handledCount))
+			rejected := int(atomic.LoadInt32(rejectedCount))
 
 			// If state not changed don't send it.
-			if msg.Indexed != handledCount {
-				msg.Indexed = handledCount
+			if msg.Indexed != count || msg.Rejected != rejected {
+				msg.Indexed = count
+				msg.Rejected = rejected
 				err := socket.WriteJSON(msg)
 				if err != nil {
-					break
+					return
 				}
 			}
 
 			// Periodicity.
-			time.Sleep(100 * time.Millisecond)
+			select {
+			case <-ticker.C:
+			case <-disconnected:
+				return
+			case <-shutdown:
+				// Ask the client to close the connection, which ends the reading below,
+				// and don't wait for it more than closeTimeout.
+				closeMsg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "Server shutting down")
+				socket.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(closeTimeout))
+				socket.SetReadDeadline(time.Now().Add(closeTimeout))
+				return
+			}
 		}
 	}()
 
@@ -173,31 +477,34 @@ func (h *aggregatorHandler) handleMonitor(w http.ResponseWriter, r *http.Request
 	}
 }
 
-// uploadLogs uploads query traces from a log file.
-func (h *aggregatorHandler) uploadLogs(filePath string) {
-	file, _ := os.Open(filePath)
+// uploadLogs uploads query traces from a log file till ctx is done,
+// it returns once the read query traces are indexed.
+func (h *aggregatorHandler) uploadLogs(ctx context.Context, source logsSource) {
+	traceReader, file, err := source.open(ctx, func(*indexer.TraceError) {
+		atomic.AddInt32(&h.rejectedCount, 1)
+	})
+	if err != nil {
+		log.Fatalf("aggregatorHandler.uploadLogs(): %v", err)
+	}
 	defer file.Close()
 
-	traceReader := indexer.NewTraceReader(file)
+	// Index query traces by batches, counting them once indexed.
+	pipelineOptions := indexer.DefaultPipelineOptions
+	pipelineOptions.OnAdded = func(n int) {
+		atomic.AddInt32(&h.handledCount, int32(n))
+	}
+	pipeline := indexer.NewPipeline(h.aggregator, pipelineOptions)
+	defer pipeline.Close()
 
-	// Index each query trace in a concurrent way
-	var wg sync.WaitGroup
 	for trace, err := traceReader.Read(); !errors.Is(err, io.EOF); trace, err = traceReader.Read() {
+		if err != nil && ctx.Err() != nil {
+			log.Println("Logs upload stopped, draining the indexation")
+			return
+		}
 		if err != nil {
 			log.Fatalf("aggregatorHandler.uploadLogs(): %v", err)
 		}
 
-		wg.Add(1)
-		go func(trace indexer.Trace) {
-			defer wg.Done()
-
-			// Add query trace to an index aggregator containing all indexes.
-			h.aggregator.Add(trace)
-
-			// Increment the number of handles query traces.
-			atomic.AddInt32(&h.handledCount, 1)
-		}(trace)
+		pipeline.Add(trace)
 	}
-
-	wg.Wait()
 }
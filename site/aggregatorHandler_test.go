@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cosaques/algolia/indexer"
+	"github.com/gorilla/websocket"
+)
+
+func TestAggregatorHandlerTraces(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+		want        TracesResponse
+	}{
+		{
+			"Tsv",
+			"text/tab-separated-values",
+			"2015-08-01 00:03:43\tq1\n2015-08-01 00:03:44\tq2\textra\n2015-08-01T00:03:45\tq3\n2015-08-01 00:03:46\tq4\n",
+			http.StatusOK,
+			TracesResponse{Accepted: 2, Errors: []TraceErrorResponse{{Line: 2}, {Line: 3}}},
+		},
+		{
+			"Json",
+			"application/json",
+			`[{"date": "2015-08-01 00:03:43", "query": "q1"}, {"date": "2015-08-01", "query": "q2"}, {"date": "2015-08-01 00:03:46", "query": "q4"}]`,
+			http.StatusOK,
+			TracesResponse{Accepted: 2, Errors: []TraceErrorResponse{{Line: 2}}},
+		},
+		{
+			"JsonDetected",
+			"",
+			` [{"date": "2015-08-01 00:03:43", "query": "q1"}]`,
+			http.StatusOK,
+			TracesResponse{Accepted: 1},
+		},
+		{
+			"JsonMistyped",
+			"application/json",
+			`[{"date": "2015-08-01 00:03:43", "query": "q1"}, {"date": 123, "query": "q2"}, {"query": ["q3"]}, {"date": "2015-08-01 00:03:46", "query": "q4"}]`,
+			http.StatusOK,
+			TracesResponse{Accepted: 2, Errors: []TraceErrorResponse{{Line: 2}, {Line: 3}}},
+		},
+		{
+			"JsonMalformed",
+			"application/json",
+			`[{"date": "2015-08-01 00:03:43", "query": "q1"}, {"date": `,
+			http.StatusBadRequest,
+			TracesResponse{Accepted: 1, Errors: []TraceErrorResponse{{Line: 0}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newAggregatorHandler()
+			r := httptest.NewRequest(http.MethodPost, "/1/queries/traces", strings.NewReader(tt.body))
+			r.Header.Set("content-type", tt.contentType)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Fatalf("Status = %d, want %d", w.Code, tt.status)
+			}
+			var got TracesResponse
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatalf("Error %v occured", err)
+			}
+			if got.Accepted != tt.want.Accepted || len(got.Errors) != len(tt.want.Errors) {
+				t.Fatalf("Response = %+v, want %+v", got, tt.want)
+			}
+			for i, e := range got.Errors {
+				if e.Line != tt.want.Errors[i].Line || e.Error == "" {
+					t.Errorf("Error %d = %+v, want line %d", i, e, tt.want.Errors[i].Line)
+				}
+			}
+		})
+	}
+}
+
+func TestAggregatorHandlerTracesTooLarge(t *testing.T) {
+	h := newAggregatorHandler()
+	h.maxBodySize = 100
+	body := strings.Repeat("2015-08-01 00:03:43\tq1\n", 10)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/1/queries/traces", strings.NewReader(body)))
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	var got TracesResponse
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("Error %v occured", err)
+	}
+	if got.Accepted >= 10 || len(got.Errors) != 1 || got.Errors[0].Line != 0 {
+		t.Errorf("Response = %+v, want less than 10 accepted traces and a body error", got)
+	}
+}
+
+func TestAggregatorHandlerTimeZone(t *testing.T) {
+	h := newAggregatorHandler()
+	h.aggregator.Add(indexer.Trace{Date: time.Date(2015, 8, 1, 22, 30, 0, 0, time.UTC), Query: "q1"})
+	h.aggregator.Add(indexer.Trace{Date: time.Date(2015, 8, 2, 22, 30, 0, 0, time.UTC), Query: "q2"})
+
+	tests := []struct {
+		name     string
+		location string
+		url      string
+		status   int
+		count    int
+	}{
+		{"UTC", "", "/1/queries/count/2015-08-02", http.StatusOK, 1},
+		{"Parameter", "", "/1/queries/count/2015-08-02?tz=Europe/Paris", http.StatusOK, 1},
+		{"ParameterRange", "", "/1/queries/count?from=2015-08-02&to=2015-08-04&tz=America/Los_Angeles", http.StatusOK, 1},
+		{"Default", "Asia/Tokyo", "/1/queries/count/2015-08-02", http.StatusOK, 1},
+		{"DefaultOverridden", "Asia/Tokyo", "/1/queries/count/2015-08-03?tz=UTC", http.StatusOK, 0},
+		{"Unknown", "", "/1/queries/count/2015-08-02?tz=Mars/Olympus", http.StatusBadRequest, 0},
+		{"UnknownRange", "", "/1/queries/popular?from=2015-08-01&to=2015-08-03&size=1&tz=Mars/Olympus", http.StatusBadRequest, 0},
+		{"EmptyRange", "", "/1/queries/count?from=2015-08-02&to=2015-08-02", http.StatusBadRequest, 0},
+		{"ReversedRange", "", "/1/queries/count?from=2015-08-03&to=2015-08-01%2012", http.StatusBadRequest, 0},
+		{"ReversedSeries", "", "/1/queries/series?from=2015-08-03&to=2015-08-01&query=q1&step=day", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h.location = time.UTC
+			if tt.location != "" {
+				h.location, _ = time.LoadLocation(tt.location)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.url, nil))
+
+			if w.Code != tt.status {
+				t.Fatalf("Status = %d, want %d", w.Code, tt.status)
+			} else if w.Code != http.StatusOK {
+				return
+			}
+			var got CountResponse
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatalf("Error %v occured", err)
+			}
+			if got.Count != tt.count {
+				t.Errorf("Count = %d, want %d", got.Count, tt.count)
+			}
+		})
+	}
+}
+
+func TestAggregatorHandlerPrecisionNotIndexed(t *testing.T) {
+	h := newAggregatorHandler(indexer.WithPrecisions(indexer.Day))
+	for url, status := range map[string]int{
+		"/1/queries/count/2015-08-01":                          http.StatusOK,
+		"/1/queries/count/2015-08":                             http.StatusBadRequest,
+		"/1/queries/popular/2015-08-01 00?size=3":              http.StatusBadRequest,
+		"/1/queries/count?from=2015-08-01 12&to=2015-08-02 00": http.StatusBadRequest,
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, strings.ReplaceAll(url, " ", "%20"), nil))
+		if w.Code != status {
+			t.Errorf("GET %s status = %d, want %d", url, w.Code, status)
+		}
+	}
+}
+
+func TestAggregatorHandlerSize(t *testing.T) {
+	h := newAggregatorHandler()
+	h.aggregator.Add(indexer.Trace{Date: time.Date(2015, 8, 1, 22, 30, 0, 0, time.UTC), Query: "q1"})
+	for url, status := range map[string]int{
+		"/1/queries/popular/2015-08-01?size=1":                            http.StatusOK,
+		"/1/queries/popular/2015-08-01?size=-1":                           http.StatusBadRequest,
+		"/1/queries/popular?from=2015-07-31&to=2015-08-02&size=-1":        http.StatusBadRequest,
+		"/1/queries/popular/2015-08-01?size=ten":                          http.StatusBadRequest,
+		"/1/queries/search/2015-08-01?prefix=q&size=-1":                   http.StatusBadRequest,
+		"/1/queries/search?from=2015-07-31&to=2015-08-02&prefix=q&size=0": http.StatusOK,
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		if w.Code != status {
+			t.Errorf("GET %s status = %d, want %d", url, w.Code, status)
+		}
+	}
+}
+
+func TestAggregatorHandlerSeries(t *testing.T) {
+	h := newAggregatorHandler()
+	h.aggregator.Add(indexer.Trace{Date: time.Date(2015, 8, 1, 22, 30, 0, 0, time.UTC), Query: "q1"})
+	h.aggregator.Add(indexer.Trace{Date: time.Date(2015, 8, 3, 10, 0, 0, 0, time.UTC), Query: "q1"})
+	h.aggregator.Add(indexer.Trace{Date: time.Date(2015, 8, 3, 11, 0, 0, 0, time.UTC), Query: "q1"})
+
+	tests := []struct {
+		url    string
+		status int
+		want   string
+	}{
+		{"/1/queries/series?from=2015-08-01%2021&to=2015-08-02&query=q1&step=hour", http.StatusOK, "[{2015-08-01 21 0} {2015-08-01 22 1} {2015-08-01 23 0}]"},
+		{"/1/queries/series/2015-W31?query=q1&step=day", http.StatusOK, "[{2015-07-27 0} {2015-07-28 0} {2015-07-29 0} {2015-07-30 0} {2015-07-31 0} {2015-08-01 1} {2015-08-02 0}]"},
+		{"/1/queries/series?from=2015-08-01&to=2015-08-04&query=q1&step=day", http.StatusOK, "[{2015-08-01 1} {2015-08-02 0} {2015-08-03 2}]"},
+		{"/1/queries/series?from=2015-08-01&to=2015-08-04&query=q1&step=day&tz=Europe/Paris", http.StatusOK, "[{2015-08-01 0} {2015-08-02 1} {2015-08-03 2}]"},
+		{"/1/queries/series/2015-08?query=q2&step=month", http.StatusOK, "[{2015-08 0}]"},
+		{"/1/queries/series/2015-08?step=day", http.StatusBadRequest, ""},
+		{"/1/queries/series/2015-08?query=q1", http.StatusBadRequest, ""},
+		{"/1/queries/series/2015-08?query=q1&step=decade", http.StatusBadRequest, ""},
+		{"/1/queries/series/2015-08?query=q1&step=week", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.url, nil))
+		if w.Code != tt.status {
+			t.Errorf("GET %s status = %d, want %d", tt.url, w.Code, tt.status)
+			continue
+		} else if w.Code != http.StatusOK {
+			continue
+		}
+
+		var got SeriesResponse
+		if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+			t.Fatalf("Error %v occured", err)
+		}
+		if fmt.Sprint(got.Points) != tt.want {
+			t.Errorf("GET %s points = %v, want %s", tt.url, got.Points, tt.want)
+		}
+	}
+}
+
+func TestAggregatorHandlerTrending(t *testing.T) {
+	h := newAggregatorHandler()
+	for query, counts := range map[string][2]int{"q1": {1, 10}, "q2": {0, 5}, "q3": {5, 1}} {
+		for day, count := range counts {
+			for i := 0; i < count; i++ {
+				h.aggregator.Add(indexer.Trace{Date: time.Date(2015, 8, 1+day, 0, 0, i, 0, time.UTC), Query: query})
+			}
+		}
+	}
+
+	tests := []struct {
+		url    string
+		status int
+		want   string
+	}{
+		{"/1/queries/trending/2015-08-02?size=5", http.StatusOK, "delta [{q1 10 1 9} {q2 5 0 5}]"},
+		{"/1/queries/trending/2015-08-02?size=1&method=ratio", http.StatusOK, "ratio [{q2 5 0 6}]"},
+		{"/1/queries/trending/2015-08-01?size=5&method=zscore", http.StatusOK, "zscore [{q3 5 0 5} {q1 1 0 1}]"},
+		{"/1/queries/trending/2015-08-02?method=zscore", http.StatusBadRequest, ""},
+		{"/1/queries/trending/2015-08-02?size=5&method=mean", http.StatusBadRequest, ""},
+		{"/1/queries/trending/2015-08-02?size=-1", http.StatusBadRequest, ""},
+		{"/1/queries/trending?from=2015-08-01&to=2015-08-03&size=5", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.url, nil))
+		if w.Code != tt.status {
+			t.Errorf("GET %s status = %d, want %d", tt.url, w.Code, tt.status)
+			continue
+		} else if w.Code != http.StatusOK {
+			continue
+		}
+
+		var got TrendingResponse
+		if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+			t.Fatalf("Error %v occured", err)
+		}
+		if fmt.Sprint(got.Method, " ", got.Queries) != tt.want {
+			t.Errorf("GET %s = %v %v, want %s", tt.url, got.Method, got.Queries, tt.want)
+		}
+	}
+}
+
+func TestAggregatorHandlerUploadLogsCancel(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "logs.tsv")
+	if err := os.WriteFile(filePath, []byte("2015-08-01 00:03:43\tq1\n2015-08-01 00:03:44\tq2\n"), 0644); err != nil {
+		t.Fatalf("Error %v occured", err)
+	}
+	h := newAggregatorHandler()
+
+	// The upload of a followed file only stops once cancelled.
+	ctx, cancel := context.WithCancel(context.Background())
+	uploaded := make(chan struct{})
+	go func() {
+		defer close(uploaded)
+		h.uploadLogs(ctx, logsSource{filePath: filePath, follow: true, options: indexer.DefaultReaderOptions})
+	}()
+	// Let the lines be read, their batch isn't indexed before the next flush of the pipeline.
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-uploaded:
+	case <-time.After(time.Second):
+		t.Fatalf("Upload not stopped by the cancellation")
+	}
+	r, _ := indexer.ParseTimeRange("2015-08-01")
+	if idx, err := h.aggregator.GetIndex(r); err != nil || idx == nil || idx.Len() != 2 {
+		t.Errorf("GetIndex(%v) = (%v, %v) after the upload, want 2 queries", r, idx, err)
+	}
+}
+
+func TestAggregatorHandlerMonitoringShutdown(t *testing.T) {
+	shutdown := make(chan struct{})
+	h := newAggregatorHandler()
+	h.shutdown = shutdown
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	socket, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/1/queries/monitoring", nil)
+	if err != nil {
+		t.Fatalf("Error %v occured", err)
+	}
+	defer socket.Close()
+
+	close(shutdown)
+	socket.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err = socket.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseGoingAway {
+		t.Fatalf("Read error %v, want a close with code %d", err, websocket.CloseGoingAway)
+	}
+}
+
+func TestAggregatorHandlerSaveSnapshotConcurrently(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "snapshot.idx")
+	h := newAggregatorHandler()
+	for i := 0; i < 1000; i++ {
+		h.aggregator.Add(indexer.Trace{Date: time.Date(2015, 8, 1, 0, 0, i%60, 0, time.UTC), Query: fmt.Sprintf("q%d", i)})
+	}
+
+	// Periodic and final saves could overlap.
+	errs := make(chan error)
+	for i := 0; i < 5; i++ {
+		go func() {
+			errs <- h.saveSnapshot(filePath)
+		}()
+	}
+	for i := 0; i < 5; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("Error %v occured", err)
+		}
+	}
+
+	loaded := newAggregatorHandler()
+	if err := loaded.loadSnapshot(filePath); err != nil {
+		t.Fatalf("Error %v occured", err)
+	}
+	r, _ := indexer.ParseTimeRange("2015-08-01")
+	if idx, _ := loaded.aggregator.GetIndex(r); idx == nil || idx.Len() != 1000 {
+		t.Errorf("GetIndex(%v) = %v after loading, want 1000 queries", r, idx)
+	}
+}
@@ -0,0 +1,505 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cosaques/algolia/indexer"
+)
+
+const (
+	// batchSize is the number of query traces sent at once to a backend.
+	batchSize = 1000
+	// flushInterval is the periodicity of sending incomplete batches.
+	flushInterval = time.Second
+	// backendTimeout is the maximum duration of a request to a backend.
+	backendTimeout = 30 * time.Second
+)
+
+// facadeHandler is an http.Handler spreading queries among several backends,
+// each backend being a server running aggregatorHandler.
+type facadeHandler struct {
+	// backends are the base URLs of backends.
+	backends []string
+	// client sends requests to backends.
+	client *http.Client
+	// handledCount keeps number of handled logs.
+	handledCount int32
+	// rejectedCount keeps number of logs which can't be read.
+	rejectedCount int32
+	// location is the time zone of dates sent to backends when requests have no "tz" parameter,
+	// backends use their own if empty.
+	location string
+	// normalize transforms queries like backends do before routing them,
+	// so that the variants of a query are sent to the same backend.
+	normalize indexer.Normalizer
+	// shutdown is closed when the server shuts down, closing the monitoring sockets.
+	shutdown <-chan struct{}
+	// maxBodySize is the maximum size in bytes of the request bodies of traces.
+	maxBodySize int64
+}
+
+// backendError is returned when a backend doesn't respond with a success.
+type backendError struct {
+	status int
+	body   string
+}
+
+func (e *backendError) Error() string {
+	return e.body
+}
+
+// newFacadeHandler creates a new instance of facadeHandler
+func newFacadeHandler(backends []string) *facadeHandler {
+	return &facadeHandler{
+		backends:    backends,
+		client:      &http.Client{Timeout: backendTimeout},
+		maxBodySize: defaultMaxBodySize,
+	}
+}
+
+// ServeHTTP implements http.Handler interface.
+func (h *facadeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Split the URL to defince which API action is called.
+	segs := strings.Split(r.URL.Path, "/")
+	action := segs[3]
+
+	switch action {
+	// /1/queries/count/<DATE_PREFIX> or /1/queries/count?from=<FROM>&to=<TO>
+	case "count":
+		// Queries don't intersect among backends, so their counts are summed.
+		resp := CountResponse{}
+		err := h.fanOut(h.requestURI(r), func() interface{} {
+			return &CountResponse{}
+		}, func(v interface{}) {
+			backendResp := v.(*CountResponse)
+			resp.Count += backendResp.Count
+			if backendResp.Approximate {
+				resp.Approximate = true
+				if backendResp.ErrorRate > resp.ErrorRate {
+					resp.ErrorRate = backendResp.ErrorRate
+				}
+			}
+		})
+		if err != nil {
+			writeBackendError(w, err)
+			return
+		}
+
+		writeJSON(w, resp)
+	// /1/queries/popular/<DATE_PREFIX>?size=<SIZE> or /1/queries/popular?from=<FROM>&to=<TO>&size=<SIZE>
+	// /1/queries/search/<DATE_PREFIX>?prefix=<PREFIX>&contains=<CONTAINS>&size=<SIZE> or
+	// /1/queries/search?from=<FROM>&to=<TO>&prefix=<PREFIX>&contains=<CONTAINS>&size=<SIZE>
+	case "popular", "search":
+		queryValues, err := url.ParseQuery(r.URL.RawQuery)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		size, err := parseSize(queryValues)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Each backend returns its top (found) queries, the most popular among them are kept.
+		resp := PopularResponse{Queries: []QueryCountResponse{}}
+		err = h.fanOut(h.requestURI(r), func() interface{} {
+			return &PopularResponse{}
+		}, func(v interface{}) {
+			resp.Queries = append(resp.Queries, v.(*PopularResponse).Queries...)
+		})
+		if err != nil {
+			writeBackendError(w, err)
+			return
+		}
+
+		// Queries are sorted like the backends do, alphabetically for the same count.
+		sort.Slice(resp.Queries, func(i, j int) bool {
+			if resp.Queries[i].Count != resp.Queries[j].Count {
+				return resp.Queries[i].Count > resp.Queries[j].Count
+			}
+			return resp.Queries[i].Query < resp.Queries[j].Query
+		})
+		if size < len(resp.Queries) {
+			resp.Queries = resp.Queries[:size]
+		}
+
+		writeJSON(w, resp)
+	// /1/queries/trending/<DATE_PREFIX>?size=<SIZE>&method=<METHOD>
+	case "trending":
+		queryValues, err := url.ParseQuery(r.URL.RawQuery)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		size, method, err := parseTrendingParams(queryValues)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Scores only depend on the counts of a query, which is on a single backend,
+		// so the highest ones among backends are kept.
+		resp := TrendingResponse{Method: method.String(), Queries: []TrendingQueryResponse{}}
+		err = h.fanOut(h.requestURI(r), func() interface{} {
+			return &TrendingResponse{}
+		}, func(v interface{}) {
+			resp.Queries = append(resp.Queries, v.(*TrendingResponse).Queries...)
+		})
+		if err != nil {
+			writeBackendError(w, err)
+			return
+		}
+
+		sort.Slice(resp.Queries, func(i, j int) bool {
+			if resp.Queries[i].Score != resp.Queries[j].Score {
+				return resp.Queries[i].Score > resp.Queries[j].Score
+			}
+			if resp.Queries[i].Count != resp.Queries[j].Count {
+				return resp.Queries[i].Count > resp.Queries[j].Count
+			}
+			return resp.Queries[i].Query < resp.Queries[j].Query
+		})
+		if size >= 0 && size < len(resp.Queries) {
+			resp.Queries = resp.Queries[:size]
+		}
+
+		writeJSON(w, resp)
+	// /1/queries/series/<DATE_PREFIX>?query=<QUERY>&step=<STEP> or
+	// /1/queries/series?from=<FROM>&to=<TO>&query=<QUERY>&step=<STEP>
+	case "series":
+		// Backends return the same time ranges, the counts of each one are summed.
+		var resp *SeriesResponse
+		err := h.fanOut(h.requestURI(r), func() interface{} {
+			return &SeriesResponse{}
+		}, func(v interface{}) {
+			backendResp := v.(*SeriesResponse)
+			if resp == nil {
+				resp = backendResp
+				return
+			}
+			for i := range resp.Points {
+				if i < len(backendResp.Points) {
+					resp.Points[i].Count += backendResp.Points[i].Count
+				}
+			}
+		})
+		if err != nil {
+			writeBackendError(w, err)
+			return
+		}
+
+		writeJSON(w, resp)
+	// /1/queries/variants?query=<QUERY>
+	case "variants":
+		// Variants of a query are on a single backend if they are routed
+		// with the same normalization, they are summed otherwise.
+		counts := make(map[string]int)
+		err := h.fanOut(r.URL.RequestURI(), func() interface{} {
+			return &VariantsResponse{}
+		}, func(v interface{}) {
+			for _, variant := range v.(*VariantsResponse).Variants {
+				counts[variant.Query] += variant.Count
+			}
+		})
+		if err != nil {
+			writeBackendError(w, err)
+			return
+		}
+
+		resp := VariantsResponse{Query: r.URL.Query().Get("query"), Variants: []QueryCountResponse{}}
+		for query, count := range counts {
+			resp.Variants = append(resp.Variants, QueryCountResponse{Query: query, Count: count})
+		}
+		sort.Slice(resp.Variants, func(i, j int) bool {
+			if resp.Variants[i].Count != resp.Variants[j].Count {
+				return resp.Variants[i].Count > resp.Variants[j].Count
+			}
+			return resp.Variants[i].Query < resp.Variants[j].Query
+		})
+		writeJSON(w, resp)
+	// /1/queries/monitoring
+	case "monitoring":
+		serveMonitor(h.shutdown, &h.handledCount, &h.rejectedCount, w, r)
+	// POST /1/queries/traces
+	case "traces":
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// The body is bounded before being decompressed.
+		r.Body = http.MaxBytesReader(w, r.Body, h.maxBodySize)
+		traceReader, body, err := newRequestTraceReader(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer body.Close()
+
+		resp, err := h.route(traceReader)
+		if err != nil {
+			var backendErr *backendError
+			if errors.As(err, &backendErr) {
+				writeBackendError(w, err)
+				return
+			}
+
+			// The rest of query traces can't be read.
+			resp.Errors = append(resp.Errors, TraceErrorResponse{Error: err.Error()})
+			w.Header().Set("content-type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+
+		writeJSON(w, resp)
+	}
+}
+
+// requestURI returns the URI of a request to send to backends.
+func (h *facadeHandler) requestURI(r *http.Request) string {
+	if h.location == "" || r.URL.Query().Get("tz") != "" {
+		return r.URL.RequestURI()
+	}
+
+	u := *r.URL
+	queryValues := u.Query()
+	queryValues.Set("tz", h.location)
+	u.RawQuery = queryValues.Encode()
+	return u.RequestURI()
+}
+
+// fanOut sends a GET request to all the backends and decodes their JSON responses
+// into values created by newResp, then merges them one by one.
+func (h *facadeHandler) fanOut(requestURI string, newResp func() interface{}, merge func(interface{})) error {
+	var wg sync.WaitGroup
+	var mux sync.Mutex
+	var firstErr error
+	for _, backend := range h.backends {
+		wg.Add(1)
+		go func(backend string) {
+			defer wg.Done()
+
+			v := newResp()
+			err := h.get(backend+requestURI, v)
+
+			mux.Lock()
+			defer mux.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			merge(v)
+		}(backend)
+	}
+	wg.Wait()
+
+	return firstErr
+}
+
+// get sends a GET request and decodes its JSON response into v.
+func (h *facadeHandler) get(url string, v interface{}) error {
+	resp, err := h.client.Get(url)
+	if err != nil {
+		return fmt.Errorf("facadeHandler.get(): %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return &backendError{resp.StatusCode, string(body)}
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("facadeHandler.get(): %w", err)
+	}
+	return nil
+}
+
+// backendFor returns the index of the backend a query belongs to.
+func (h *facadeHandler) backendFor(query string) int {
+	if h.normalize != nil {
+		query = h.normalize(query)
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(query))
+	return int(hash.Sum32() % uint32(len(h.backends)))
+}
+
+// route sends query traces to the backends they belong to by batches,
+// and returns the number of traces accepted by backends and the rejected ones.
+// Incomplete batches are sent periodically, so that slow streams of traces
+// aren't delayed.
+func (h *facadeHandler) route(traceReader indexer.TraceReader) (TracesResponse, error) {
+	batches := make([][]indexer.Trace, len(h.backends))
+	resp := TracesResponse{}
+
+	flush := func(i int) error {
+		if len(batches[i]) == 0 {
+			return nil
+		}
+		n, err := h.post(h.backends[i], batches[i])
+		batches[i] = batches[i][:0]
+		resp.Accepted += n
+		atomic.AddInt32(&h.handledCount, int32(n))
+		return err
+	}
+	flushAll := func() error {
+		for i := range batches {
+			if err := flush(i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Read query traces in parallel, so that batches could be sent meanwhile.
+	type readResult struct {
+		trace indexer.Trace
+		err   error
+	}
+	results := make(chan readResult)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(results)
+		for trace, err := traceReader.Read(); !errors.Is(err, io.EOF); trace, err = traceReader.Read() {
+			select {
+			case results <- readResult{trace, err}:
+			case <-done:
+				return
+			}
+
+			// Only a TraceError allows to read the next query traces.
+			var traceErr *indexer.TraceError
+			if err != nil && !errors.As(err, &traceErr) {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case result, ok := <-results:
+			if !ok {
+				// All the traces are read, send the remaining batches.
+				return resp, flushAll()
+			}
+
+			var traceErr *indexer.TraceError
+			if errors.As(result.err, &traceErr) {
+				resp.Errors = append(resp.Errors, TraceErrorResponse{Line: traceErr.Line, Error: traceErr.Err.Error()})
+				continue
+			} else if result.err != nil {
+				if err := flushAll(); err != nil {
+					return resp, err
+				}
+				return resp, result.err
+			}
+
+			i := h.backendFor(result.trace.Query)
+			batches[i] = append(batches[i], result.trace)
+			if len(batches[i]) == batchSize {
+				if err := flush(i); err != nil {
+					return resp, err
+				}
+			}
+		case <-ticker.C:
+			if err := flushAll(); err != nil {
+				return resp, err
+			}
+		}
+	}
+}
+
+// post sends query traces to a backend as a tsv file,
+// and returns the number of traces it accepted.
+func (h *facadeHandler) post(backend string, traces []indexer.Trace) (int, error) {
+	var buf bytes.Buffer
+	tsvWriter := csv.NewWriter(&buf)
+	tsvWriter.Comma = '\t'
+	for _, trace := range traces {
+		tsvWriter.Write([]string{trace.Date.Format("2006-01-02 15:04:05"), trace.Query})
+	}
+	tsvWriter.Flush()
+
+	resp, err := h.client.Post(backend+"/1/queries/traces", "text/tab-separated-values", &buf)
+	if err != nil {
+		return 0, fmt.Errorf("facadeHandler.post(): %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return 0, &backendError{resp.StatusCode, string(body)}
+	}
+
+	var tracesResp TracesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tracesResp); err != nil {
+		return 0, fmt.Errorf("facadeHandler.post(): %w", err)
+	}
+	return tracesResp.Accepted, nil
+}
+
+// uploadLogs routes query traces from a log file to the backends till ctx is done,
+// it returns once the read query traces are sent.
+func (h *facadeHandler) uploadLogs(ctx context.Context, source logsSource) {
+	traceReader, file, err := source.open(ctx, func(*indexer.TraceError) {
+		atomic.AddInt32(&h.rejectedCount, 1)
+	})
+	if err != nil {
+		log.Fatalf("facadeHandler.uploadLogs(): %v", err)
+	}
+	defer file.Close()
+
+	resp, err := h.route(traceReader)
+	if err == nil && len(resp.Errors) > 0 {
+		err = fmt.Errorf("line %d: %s", resp.Errors[0].Line, resp.Errors[0].Error)
+	}
+	if ctx.Err() != nil {
+		log.Println("Logs upload stopped")
+		return
+	}
+	if err != nil {
+		log.Fatalf("facadeHandler.uploadLogs(): %v", err)
+	}
+}
+
+// writeJSON writes a successful JSON response.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeBackendError writes the error of a backend keeping its status if any.
+func writeBackendError(w http.ResponseWriter, err error) {
+	var backendErr *backendError
+	if errors.As(err, &backendErr) {
+		http.Error(w, strings.TrimSpace(backendErr.body), backendErr.status)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadGateway)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cosaques/algolia/indexer"
+)
+
+func TestFacadeHandler(t *testing.T) {
+	// Start 3 backends behind a facade.
+	var backends []string
+	for i := 0; i < 3; i++ {
+		mux := http.NewServeMux()
+		mux.Handle("/1/queries/", newAggregatorHandler())
+		backend := httptest.NewServer(mux)
+		defer backend.Close()
+		backends = append(backends, backend.URL)
+	}
+	facadeMux := http.NewServeMux()
+	facadeMux.Handle("/1/queries/", newFacadeHandler(backends))
+	facade := httptest.NewServer(facadeMux)
+	defer facade.Close()
+
+	// Query i occurs i times on 2015-08-01 and once on 2015-08-02.
+	var tsv strings.Builder
+	for i := 1; i <= 20; i++ {
+		for j := 0; j < i; j++ {
+			fmt.Fprintf(&tsv, "2015-08-01 00:%02d:00\tQuery %d\n", j, i)
+		}
+		fmt.Fprintf(&tsv, "2015-08-02 00:00:00\tQuery %d\n", i)
+	}
+
+	resp, err := http.Post(facade.URL+"/1/queries/traces", "text/tab-separated-values", strings.NewReader(tsv.String()))
+	if err != nil {
+		t.Fatalf("Error %v occured", err)
+	}
+	var tracesResp TracesResponse
+	json.NewDecoder(resp.Body).Decode(&tracesResp)
+	resp.Body.Close()
+	if want := 20*21/2 + 20; tracesResp.Accepted != want {
+		t.Fatalf("Accepted %d traces, want %d", tracesResp.Accepted, want)
+	}
+
+	// Each backend got a part of the queries.
+	for _, backend := range backends {
+		var countResp CountResponse
+		getJSON(t, backend+"/1/queries/count/2015", &countResp)
+		if countResp.Count == 0 || countResp.Count == 20 {
+			t.Errorf("Backend %s count = %d, want a part of %d", backend, countResp.Count, 20)
+		}
+	}
+
+	t.Run("Count", func(t *testing.T) {
+		var countResp CountResponse
+		getJSON(t, facade.URL+"/1/queries/count/2015", &countResp)
+		if countResp.Count != 20 {
+			t.Errorf("Count = %d, want %d", countResp.Count, 20)
+		}
+
+		getJSON(t, facade.URL+"/1/queries/count?from=2015-08-02&to=2015-08-03", &countResp)
+		if countResp.Count != 20 {
+			t.Errorf("Range count = %d, want %d", countResp.Count, 20)
+		}
+	})
+
+	t.Run("Popular", func(t *testing.T) {
+		var popularResp PopularResponse
+		getJSON(t, facade.URL+"/1/queries/popular/2015-08-01?size=5", &popularResp)
+		if len(popularResp.Queries) != 5 {
+			t.Fatalf("Popular queries = %v, want %d", popularResp.Queries, 5)
+		}
+		for i, q := range popularResp.Queries {
+			want := QueryCountResponse{Query: fmt.Sprintf("Query %d", 20-i), Count: 20 - i}
+			if q != want {
+				t.Errorf("Popular %d = %v, want %v", i+1, q, want)
+			}
+		}
+
+		// Queries of different backends with the same count are sorted alphabetically.
+		getJSON(t, facade.URL+"/1/queries/popular/2015-08-02?size=3", &popularResp)
+		want := "[{Query 1 1 0} {Query 2 1 0} {Query 3 1 0}]"
+		if fmt.Sprint(popularResp.Queries) != want {
+			t.Errorf("Popular queries = %v, want %s", popularResp.Queries, want)
+		}
+	})
+
+	t.Run("Search", func(t *testing.T) {
+		for url, want := range map[string][]string{
+			"/1/queries/search/2015-08-01?prefix=Query%201&size=3":                     {"Query 19", "Query 18", "Query 17"},
+			"/1/queries/search/2015-08-01?contains=2&size=5":                           {"Query 20", "Query 12", "Query 2"},
+			"/1/queries/search?from=2015-08-02&to=2015-08-03&prefix=Query%2020&size=5": {"Query 20"},
+		} {
+			var searchResp PopularResponse
+			getJSON(t, facade.URL+url, &searchResp)
+			var got []string
+			for _, q := range searchResp.Queries {
+				got = append(got, q.Query)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("GET %s queries = %v, want %v", url, got, want)
+			}
+		}
+	})
+
+	t.Run("Series", func(t *testing.T) {
+		for i := 1; i <= 20; i += 19 {
+			var seriesResp SeriesResponse
+			getJSON(t, fmt.Sprintf("%s/1/queries/series?from=2015-07-31&to=2015-08-03&query=Query%%20%d&step=day", facade.URL, i), &seriesResp)
+			if want := fmt.Sprintf("[{2015-07-31 0} {2015-08-01 %d} {2015-08-02 1}]", i); fmt.Sprint(seriesResp.Points) != want {
+				t.Errorf("Query %d points = %v, want %s", i, seriesResp.Points, want)
+			}
+		}
+	})
+
+	t.Run("Trending", func(t *testing.T) {
+		// Queries didn't occur on 2015-07-31, so they are all new on 2015-08-01.
+		var trendingResp TrendingResponse
+		getJSON(t, facade.URL+"/1/queries/trending/2015-08-01?size=3&method=ratio", &trendingResp)
+		want := "[{Query 20 20 0 21} {Query 19 19 0 20} {Query 18 18 0 19}]"
+		if fmt.Sprint(trendingResp.Queries) != want {
+			t.Errorf("Trending queries = %v, want %s", trendingResp.Queries, want)
+		}
+	})
+
+	t.Run("NegativeSize", func(t *testing.T) {
+		for _, url := range []string{"/1/queries/popular/2015-08-01?size=-1", "/1/queries/search/2015-08-01?prefix=Query&size=-1"} {
+			resp, err := http.Get(facade.URL + url)
+			if err != nil {
+				t.Fatalf("Error %v occured", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("GET %s status = %d, want %d", url, resp.StatusCode, http.StatusBadRequest)
+			}
+		}
+	})
+
+	t.Run("BackendError", func(t *testing.T) {
+		resp, err := http.Get(facade.URL + "/1/queries/count/2015-08-01T00")
+		if err != nil {
+			t.Fatalf("Error %v occured", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusInternalServerError {
+			t.Errorf("Status = %d, want %d", resp.StatusCode, http.StatusInternalServerError)
+		}
+	})
+}
+
+func TestFacadeHandlerBackendTimeout(t *testing.T) {
+	// The backend doesn't respond till the end of the test.
+	stalled := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-stalled
+	}))
+	defer backend.Close()
+	defer close(stalled)
+
+	h := newFacadeHandler([]string{backend.URL})
+	h.client.Timeout = 100 * time.Millisecond
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/1/queries/count/2015-08-01", nil))
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("Status = %d, want %d", w.Code, http.StatusBadGateway)
+	}
+}
+
+func getJSON(t *testing.T, url string, v interface{}) {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("Error %v occured", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s status = %d, want %d", url, resp.StatusCode, http.StatusOK)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("Error %v occured", err)
+	}
+}
+
+func TestFacadeHandlerVariants(t *testing.T) {
+	normalize := indexer.ChainNormalizers(indexer.TrimSpace, indexer.CaseFold)
+
+	// Start 2 normalizing backends behind a facade.
+	var backends []string
+	for i := 0; i < 2; i++ {
+		mux := http.NewServeMux()
+		mux.Handle("/1/queries/", newAggregatorHandler(indexer.WithNormalizers(normalize)))
+		backend := httptest.NewServer(mux)
+		defer backend.Close()
+		backends = append(backends, backend.URL)
+	}
+	facadeHandler := newFacadeHandler(backends)
+	facadeHandler.normalize = normalize
+	facadeMux := http.NewServeMux()
+	facadeMux.Handle("/1/queries/", facadeHandler)
+	facade := httptest.NewServer(facadeMux)
+	defer facade.Close()
+
+	tsv := "2015-08-01 00:00:00\tGolang\n2015-08-01 00:00:01\tgolang \n2015-08-01 00:00:02\tGOLANG\n2015-08-01 00:00:03\tRust\n"
+	resp, err := http.Post(facade.URL+"/1/queries/traces", "text/tab-separated-values", strings.NewReader(tsv))
+	if err != nil {
+		t.Fatalf("Error %v occured", err)
+	}
+	resp.Body.Close()
+
+	var countResp CountResponse
+	getJSON(t, facade.URL+"/1/queries/count/2015-08-01", &countResp)
+	if countResp.Count != 2 {
+		t.Errorf("Count = %d, want %d", countResp.Count, 2)
+	}
+
+	var variantsResp VariantsResponse
+	getJSON(t, facade.URL+"/1/queries/variants?query=golang", &variantsResp)
+	want := []QueryCountResponse{{Query: "GOLANG", Count: 1}, {Query: "Golang", Count: 1}, {Query: "golang ", Count: 1}}
+	if fmt.Sprint(variantsResp.Variants) != fmt.Sprint(want) {
+		t.Errorf("Variants = %v, want %v", variantsResp.Variants, want)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+
+	"github.com/cosaques/algolia/indexer"
+)
+
+// followInterval is the periodicity of checks for new logs at the end of a followed file.
+const followInterval = 500 * time.Millisecond
+
+// logsSource describes a log file of queries to upload.
+type logsSource struct {
+	// filePath is the path of the file, "-" stands for the standard input.
+	filePath string
+	// follow tells if the file keeps being read as it grows.
+	follow bool
+	// options describe the format of the file.
+	options indexer.ReaderOptions
+	// policy tells how lines which can't be read are handled.
+	policy indexer.ErrorPolicy
+	// deadLetterPath is the path of the file rejected lines are appended to
+	// with indexer.RecordOnError policy.
+	deadLetterPath string
+}
+
+// multiCloser closes several io.Closer at once.
+type multiCloser []io.Closer
+
+// Close closes all the closers and returns the first error.
+func (c multiCloser) Close() error {
+	var firstErr error
+	for _, closer := range c {
+		if err := closer.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// open opens the log file and returns a TraceReader reading it according to the policy
+// till ctx is done, onReject is called for each rejected line. A followed file can't be compressed.
+func (s logsSource) open(ctx context.Context, onReject func(*indexer.TraceError)) (indexer.TraceReader, io.Closer, error) {
+	var file io.ReadCloser
+	var err error
+	if s.follow && s.filePath != "-" {
+		file, err = indexer.FollowFile(ctx, s.filePath, followInterval)
+	} else {
+		file, err = indexer.OpenTraceFile(s.filePath)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	traceReader, err := indexer.NewTraceReaderWith(file, s.options)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	var deadLetter *os.File
+	if s.policy == indexer.RecordOnError {
+		deadLetter, err = os.OpenFile(s.deadLetterPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		traceReader = indexer.NewLenientTraceReader(traceReader, s.policy, deadLetter, onReject)
+		return indexer.NewContextTraceReader(ctx, traceReader), multiCloser{file, deadLetter}, nil
+	}
+	traceReader = indexer.NewLenientTraceReader(traceReader, s.policy, nil, onReject)
+	return indexer.NewContextTraceReader(ctx, traceReader), file, nil
+}
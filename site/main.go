@@ -1,29 +1,246 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	// Time zones are available even on systems without a tz database.
+	_ "time/tzdata"
+
+	"github.com/cosaques/algolia/indexer"
 )
 
 func main() {
 	// Allowed flags.
 	addr := flag.String("addr", ":5000", "The addr of the application")
-	file := flag.String("file", "", "The path to .tsv file containing logs")
+	file := flag.String("file", "", "The path to the file containing logs (see -format), optionally compressed with gzip or zstd, \"-\" for the standard input")
+	snapshot := flag.String("snapshot", "", "The path to a snapshot file of indexes, loaded at startup instead of the logs file and saved periodically")
+	snapshotInterval := flag.Duration("snapshot-interval", 5*time.Minute, "The periodicity of snapshot saves")
+	indexDir := flag.String("index-dir", "", "The directory to store indexes in, they are kept in memory if empty")
+	indexHead := flag.Int("index-head", 100000, "The number of distinct queries of an index kept in memory when stored in -index-dir")
+	approx := flag.Float64("approx", 0, "The standard error of distinct counts of years, quarters, months, weeks and days (e.g. 0.01), they are exact if 0")
+	topK := flag.Int("topk", 0, "The number of most popular queries kept by years, quarters, months, weeks and days, they keep all the queries if 0")
+	mode := flag.String("mode", "server", "The mode of the application: \"server\" indexing logs or \"facade\" spreading them among -backends")
+	backends := flag.String("backends", "", "The comma-separated base URLs of servers in facade mode, e.g. http://host1:5000,http://host2:5000")
+	follow := flag.Bool("follow", false, "Keep reading the logs file as it grows, like tail -F does")
+	onError := flag.String("on-error", "fail", "What to do with lines of the logs file which can't be read: \"fail\", \"skip\" or \"record\" them to -dead-letter")
+	precisions := flag.String("precisions", "", "The comma-separated precisions of maintained indexes among year, quarter, month, week, day, hour, minute and second, all of them if empty")
+	normalize := flag.String("normalize", "", "The comma-separated normalizers applied in order to queries before indexing them among url (URL-decoding), nfkc, fold (case folding), space (white spaces collapsing) and trim, e.g. url,nfkc,fold,space,trim")
+	stopWords := flag.String("stop-words", "", "The comma-separated words removed from queries after -normalize")
+	maxVariants := flag.Int("max-variants", 100000, "The maximum number of raw queries counted as variants of the queries normalized with -normalize")
+	rollUp := flag.Bool("rollup", false, "Only index queries in the finest -precisions, coarser indexes being derived from them once their time range is over")
+	retention := flag.String("retention", "", "The comma-separated retentions of indexes by precision after the latest indexed query, e.g. minute=48h,hour=2160h, indexes of other precisions are kept forever")
+	tz := flag.String("tz", "UTC", "The default time zone (IANA name, e.g. Europe/Paris) of dates in requests, overridden by their \"tz\" parameter")
+	deadLetter := flag.String("dead-letter", "rejected.tsv", "The path of the file lines rejected with -on-error=record are appended to")
+	maxBodySize := flag.Int64("max-body-size", defaultMaxBodySize, "The maximum size in bytes of the request bodies of query traces, before their decompression")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "The maximum duration of the graceful shutdown on SIGINT or SIGTERM, waiting for requests and the logs upload to complete")
+	readerOptions := indexer.DefaultReaderOptions
+	readerOptions.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
-	aggregatorHandler := newAggregatorHandler()
+	policy, err := indexer.ParseErrorPolicy(*onError)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	location, err := time.LoadLocation(*tz)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	source := logsSource{filePath: *file, follow: *follow, options: readerOptions, policy: policy, deadLetterPath: *deadLetter}
+
+	// Shut down gracefully at the first SIGINT or SIGTERM, the next one kills the application.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	var uploads sync.WaitGroup
+
+	var normalizers []indexer.Normalizer
+	if *normalize != "" {
+		for _, name := range strings.Split(*normalize, ",") {
+			normalizer, err := indexer.ParseNormalizer(strings.TrimSpace(name))
+			if err != nil {
+				log.Fatalln(err)
+			}
+			normalizers = append(normalizers, normalizer)
+		}
+	}
+	if *stopWords != "" {
+		normalizers = append(normalizers, indexer.RemoveStopWords(strings.Split(*stopWords, ",")...))
+	}
+
+	switch *mode {
+	case "server":
+	case "facade":
+		if *backends == "" {
+			log.Fatalln("Facade mode requires -backends")
+		}
+		facadeHandler := newFacadeHandler(strings.Split(*backends, ","))
+		facadeHandler.location = *tz
+		facadeHandler.maxBodySize = *maxBodySize
+		facadeHandler.shutdown = ctx.Done()
+		if len(normalizers) > 0 {
+			facadeHandler.normalize = indexer.ChainNormalizers(normalizers...)
+		}
+
+		// Add possible routes and their handlers.
+		http.Handle("/", &templateHandler{fileName: "index.html"})
+		http.Handle("/1/queries/", facadeHandler)
+
+		// Route log file to backends in parallel.
+		if *file != "" {
+			uploads.Add(1)
+			go func() {
+				defer uploads.Done()
+				facadeHandler.uploadLogs(ctx, source)
+			}()
+		}
+
+		// Start the web server.
+		log.Println("Starting the facade on ", *addr)
+		serve(ctx, &http.Server{Addr: *addr}, &uploads, *shutdownTimeout)
+		log.Println("Facade stopped")
+		return
+	default:
+		log.Fatalf("Unknown mode %q", *mode)
+	}
+
+	var options []indexer.Option
+	if len(normalizers) > 0 {
+		options = append(options, indexer.WithNormalizers(normalizers...), indexer.WithMaxVariants(*maxVariants))
+	}
+	if *precisions != "" {
+		var maintained []indexer.TimePrecision
+		for _, name := range strings.Split(*precisions, ",") {
+			precision, err := indexer.ParseTimePrecision(strings.TrimSpace(name))
+			if err != nil {
+				log.Fatalln(err)
+			}
+			maintained = append(maintained, precision)
+		}
+		options = append(options, indexer.WithPrecisions(maintained...))
+	}
+	if *rollUp {
+		options = append(options, indexer.WithRollUp())
+	}
+	if *retention != "" {
+		for _, value := range strings.Split(*retention, ",") {
+			parts := strings.SplitN(strings.TrimSpace(value), "=", 2)
+			if len(parts) != 2 {
+				log.Fatalf("Retention %q should be <PRECISION>=<DURATION>", value)
+			}
+			precision, err := indexer.ParseTimePrecision(parts[0])
+			if err != nil {
+				log.Fatalln(err)
+			}
+			duration, err := time.ParseDuration(parts[1])
+			if err != nil {
+				log.Fatalln(err)
+			}
+			options = append(options, indexer.WithRetention(precision, duration))
+		}
+	}
+	if *indexDir != "" {
+		options = append(options, indexer.WithDiskIndexes(*indexDir, *indexHead))
+	}
+	if *approx > 0 {
+		options = append(options, indexer.WithApproximateCount(*approx))
+	}
+	if *topK > 0 {
+		options = append(options, indexer.WithTopK(*topK))
+	}
+	aggregatorHandler := newAggregatorHandler(options...)
+	aggregatorHandler.location = location
+	aggregatorHandler.maxBodySize = *maxBodySize
+	aggregatorHandler.shutdown = ctx.Done()
+
+	// Restore indexes from a snapshot if there is one.
+	loaded := false
+	if *snapshot != "" {
+		if err := aggregatorHandler.loadSnapshot(*snapshot, options...); err == nil {
+			log.Println("Indexes loaded from the snapshot", *snapshot)
+			loaded = true
+		} else if !errors.Is(err, os.ErrNotExist) {
+			log.Fatalln(err)
+		}
+	}
 
 	// Add possible routes and their handlers.
 	http.Handle("/", &templateHandler{fileName: "index.html"})
 	http.Handle("/1/queries/", aggregatorHandler)
 
 	// Upload and handle log file in parallel.
-	go aggregatorHandler.uploadLogs(*file)
+	if !loaded && *file != "" {
+		uploads.Add(1)
+		go func() {
+			defer uploads.Done()
+			aggregatorHandler.uploadLogs(ctx, source)
+		}()
+	}
+
+	// Save snapshots periodically.
+	var saves sync.WaitGroup
+	if *snapshot != "" {
+		saves.Add(1)
+		go func() {
+			defer saves.Done()
+			aggregatorHandler.saveSnapshots(ctx, *snapshot, *snapshotInterval)
+		}()
+	}
 
 	// Start the web server.
 	log.Println("Starting the webserver on ", *addr)
-	if err := http.ListenAndServe(*addr, nil); err != nil {
-		log.Fatalln("ListenAndServe:", err)
+	serve(ctx, &http.Server{Addr: *addr}, &uploads, *shutdownTimeout)
+
+	// Save the indexes once the ingestion is drained and the periodic saves are stopped.
+	saves.Wait()
+	if *snapshot != "" {
+		log.Println("Saving the snapshot", *snapshot)
+		if err := aggregatorHandler.saveSnapshot(*snapshot); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if err := aggregatorHandler.aggregator.Close(); err != nil {
+		log.Fatalln(err)
+	}
+	log.Println("Webserver stopped")
+}
+
+// serve runs the server till ctx is done, then shuts it down waiting for
+// the active requests and the uploads to complete, at most for timeout.
+func serve(ctx context.Context, server *http.Server, uploads *sync.WaitGroup, timeout time.Duration) {
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalln("ListenAndServe:", err)
+		}
+	}()
+	<-ctx.Done()
+
+	log.Println("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Println("Shutdown:", err)
+	}
+
+	uploaded := make(chan struct{})
+	go func() {
+		uploads.Wait()
+		close(uploaded)
+	}()
+	select {
+	case <-uploaded:
+	case <-shutdownCtx.Done():
+		log.Println("Shutdown: the logs upload isn't complete")
 	}
 }
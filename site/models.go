@@ -4,6 +4,9 @@ type (
 	// CountResponse contains count of distinct queries.
 	CountResponse struct {
 		Count int `json:"count"`
+		// Approximate tells if Count is an estimation whose standard error is ErrorRate.
+		Approximate bool    `json:"approximate,omitempty"`
+		ErrorRate   float64 `json:"errorRate,omitempty"`
 	}
 
 	// PopularResponse contains list of top popular queris.
@@ -15,10 +18,62 @@ type (
 	QueryCountResponse struct {
 		Query string `json:"query"`
 		Count int    `json:"count"`
+		// Error is the maximum overestimation of Count.
+		Error int `json:"error,omitempty"`
+	}
+
+	// TrendingResponse contains the queries which grew the most compared to
+	// the previous time range, scored by Method.
+	TrendingResponse struct {
+		Method  string                  `json:"method"`
+		Queries []TrendingQueryResponse `json:"queries"`
+	}
+
+	// TrendingQueryResponse represents a query whose count grew from PreviousCount to Count.
+	TrendingQueryResponse struct {
+		Query         string  `json:"query"`
+		Count         int     `json:"count"`
+		PreviousCount int     `json:"previousCount"`
+		Score         float64 `json:"score"`
+	}
+
+	// SeriesResponse contains the count of Query for each time range of Step.
+	SeriesResponse struct {
+		Query  string                `json:"query"`
+		Step   string                `json:"step"`
+		Points []SeriesPointResponse `json:"points"`
+	}
+
+	// SeriesPointResponse represents the count of a query during the time range of Date.
+	SeriesPointResponse struct {
+		Date  string `json:"date"`
+		Count int    `json:"count"`
+	}
+
+	// VariantsResponse contains the raw queries normalized into Query.
+	VariantsResponse struct {
+		Query    string               `json:"query"`
+		Variants []QueryCountResponse `json:"variants"`
+	}
+
+	// TracesResponse contains number of indexed query traces
+	// and the errors of rejected ones.
+	TracesResponse struct {
+		Accepted int                  `json:"accepted"`
+		Errors   []TraceErrorResponse `json:"errors,omitempty"`
+	}
+
+	// TraceErrorResponse represents a rejected query trace, Line is its line
+	// in a tsv file or its position in a JSON array. It's 0 if the rest of
+	// the query traces can't be read.
+	TraceErrorResponse struct {
+		Line  int    `json:"line"`
+		Error string `json:"error"`
 	}
 
 	// MonitoringMsg is sent to a dashboard to monitor the index progress.
 	MonitoringMsg struct {
-		Indexed int `json:"indexed"`
+		Indexed  int `json:"indexed"`
+		Rejected int `json:"rejected"`
 	}
 )
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/cosaques/algolia/indexer"
+)
+
+// loadSnapshot replaces the aggregator by the one saved in a snapshot file.
+func (h *aggregatorHandler) loadSnapshot(filePath string, options ...indexer.Option) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("aggregatorHandler.loadSnapshot(): %w", err)
+	}
+	defer file.Close()
+
+	aggregator, err := indexer.LoadAggregator(file, options...)
+	if err != nil {
+		return fmt.Errorf("aggregatorHandler.loadSnapshot(): %w", err)
+	}
+
+	// The replaced aggregator is empty, but its eviction has to be stopped.
+	h.aggregator.Close()
+	h.aggregator = aggregator
+	return nil
+}
+
+// saveSnapshot saves the aggregator to a snapshot file.
+// The file is replaced only once the snapshot is completely written.
+func (h *aggregatorHandler) saveSnapshot(filePath string) error {
+	// Snapshots share their temporary file.
+	h.snapshotMux.Lock()
+	defer h.snapshotMux.Unlock()
+
+	tmpPath := filePath + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("aggregatorHandler.saveSnapshot(): %w", err)
+	}
+
+	if _, err := h.aggregator.WriteTo(file); err != nil {
+		file.Close()
+		return fmt.Errorf("aggregatorHandler.saveSnapshot(): %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("aggregatorHandler.saveSnapshot(): %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("aggregatorHandler.saveSnapshot(): %w", err)
+	}
+
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		return fmt.Errorf("aggregatorHandler.saveSnapshot(): %w", err)
+	}
+	return nil
+}
+
+// saveSnapshots saves the aggregator to a snapshot file with a given periodicity till ctx is done.
+func (h *aggregatorHandler) saveSnapshots(ctx context.Context, filePath string, periodicity time.Duration) {
+	ticker := time.NewTicker(periodicity)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := h.saveSnapshot(filePath); err != nil {
+				log.Println(err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
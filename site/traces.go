@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/cosaques/algolia/indexer"
+)
+
+// defaultMaxBodySize is the default maximum size in bytes of the (possibly compressed) request bodies of traces.
+const defaultMaxBodySize = 256 << 20
+
+// jsonTrace represents a query trace sent as a JSON object.
+type jsonTrace struct {
+	Date  string `json:"date"`
+	Query string `json:"query"`
+}
+
+// jsonTraceReader reads traces from a JSON array of jsonTrace.
+type jsonTraceReader struct {
+	decoder *json.Decoder
+	// started tells if the beginning of the array is read.
+	started bool
+	// position is the position of the last read element, starting from 1.
+	position int
+}
+
+// Read reads the traces one by one, TraceError.Line is the position of the element.
+func (t *jsonTraceReader) Read() (indexer.Trace, error) {
+	if !t.started {
+		t.started = true
+		if token, err := t.decoder.Token(); err != nil {
+			return indexer.Trace{}, fmt.Errorf("jsonTraceReader.Read(): %w", err)
+		} else if token != json.Delim('[') {
+			return indexer.Trace{}, fmt.Errorf("jsonTraceReader.Read(): JSON array expected")
+		}
+	}
+
+	if !t.decoder.More() {
+		return indexer.Trace{}, fmt.Errorf("jsonTraceReader.Read(): %w", io.EOF)
+	}
+
+	t.position++
+	var jt jsonTrace
+	if err := t.decoder.Decode(&jt); err != nil {
+		// The element is consumed when only its fields have unexpected types.
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return indexer.Trace{}, &indexer.TraceError{Line: t.position, Err: err}
+		}
+		// The rest of the array can't be read.
+		return indexer.Trace{}, fmt.Errorf("jsonTraceReader.Read(): %w", err)
+	}
+
+	trace, err := indexer.ParseTrace([]string{jt.Date, jt.Query})
+	if err != nil {
+		return indexer.Trace{}, &indexer.TraceError{Line: t.position, Err: err}
+	}
+	return trace, nil
+}
+
+// newRequestTraceReader reads the query traces of a request body,
+// either a tsv file or a JSON array of {date, query} objects.
+// The body could be compressed with gzip or zstd.
+func newRequestTraceReader(r *http.Request) (indexer.TraceReader, io.Closer, error) {
+	body, err := indexer.Decompress(r.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// A JSON array is detected by its content type or its first character.
+	br := bufio.NewReader(body)
+	start, _ := br.Peek(512)
+	start = bytes.TrimLeft(start, " \t\r\n")
+	if strings.HasPrefix(r.Header.Get("content-type"), "application/json") || bytes.HasPrefix(start, []byte("[")) {
+		return &jsonTraceReader{decoder: json.NewDecoder(br)}, body, nil
+	}
+	return indexer.NewTraceReader(br), body, nil
+}